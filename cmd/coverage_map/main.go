@@ -0,0 +1,173 @@
+/*
+Copyright (c) 2012, Matthew H. Reilly (kb1vc)
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are
+met:
+
+    Redistributions of source code must retain the above copyright
+    notice, this list of conditions and the following disclaimer.
+    Redistributions in binary form must reproduce the above copyright
+    notice, this list of conditions and the following disclaimer in
+    the documentation and/or other materials provided with the
+    distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+"AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+// Map the predicted signal from a transmitter over the terrain around
+// it: field strength, received power, link margin or path loss for every
+// cell out to a radius, from the ITM over the map tiles. Writes the
+// values as a GridFloat raster (out.flt, out.hdr) and a colorized
+// image (out.png, out.pgw) that GIS tools can overlay on a map.
+//
+//	coverage_map -tiles /data/ned -at FN42bl -height 30 -freq 144.2 -power 50 -gain 13 -pattern yagi.txt -bearing 270 -radius 40 -out fn42
+//
+// A pattern file has one "azimuth gain" pair per line, the gain in dB
+// relative to the main lobe, which is taken to be at azimuth 0.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/kb1vc/radiopath/coverage"
+	"github.com/kb1vc/radiopath/linkbudget"
+	"github.com/kb1vc/radiopath/location"
+	"github.com/kb1vc/radiopath/nedmap"
+)
+
+// read an antenna pattern file of azimuth, relative gain pairs
+func readPattern(name string, bearing float64) (coverage.TablePattern, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return coverage.TablePattern{}, err
+	}
+	defer f.Close()
+
+	var az, gain []float64
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		txt := strings.TrimSpace(sc.Text())
+		if (txt == "") || strings.HasPrefix(txt, "#") {
+			continue
+		}
+		fields := strings.Fields(strings.Replace(txt, ",", " ", -1))
+		if len(fields) != 2 {
+			return coverage.TablePattern{}, errors.New(fmt.Sprintf("%s:%d: expected azimuth and gain, got %q", name, line, txt))
+		}
+		a, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return coverage.TablePattern{}, errors.New(fmt.Sprintf("%s:%d: bad azimuth %q", name, line, fields[0]))
+		}
+		g, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return coverage.TablePattern{}, errors.New(fmt.Sprintf("%s:%d: bad gain %q", name, line, fields[1]))
+		}
+		az, gain = append(az, a), append(gain, g)
+	}
+	if err := sc.Err(); err != nil {
+		return coverage.TablePattern{}, err
+	}
+	return coverage.NewTablePattern(az, gain, bearing)
+}
+
+func main() {
+	tiles := flag.String("tiles", ".", "directory of compressed map tiles (N43W072.dgz ...)")
+	at := flag.String("at", "", "transmitter location: grid, lat,lon, DMS, UTM or MGRS")
+	height := flag.Float64("height", 10.0, "transmitting antenna height above ground in meters")
+	rxHeight := flag.Float64("rxheight", 2.0, "receiving antenna height above ground in meters")
+	freq := flag.Float64("freq", 144.0, "frequency in MHz")
+	power := flag.Float64("power", 40.0, "transmitter output in dBm")
+	lineLoss := flag.Float64("lineloss", 0.0, "transmit feedline loss in dB")
+	gain := flag.Float64("gain", 0.0, "transmitting antenna peak gain in dBi")
+	patName := flag.String("pattern", "", "transmitting antenna pattern file (default omnidirectional)")
+	bearing := flag.Float64("bearing", 0.0, "azimuth the antenna's main lobe points toward in degrees")
+	rxGain := flag.Float64("rxgain", 0.0, "receiving antenna gain in dBi")
+	rxLineLoss := flag.Float64("rxlineloss", 0.0, "receive feedline loss in dB")
+	nf := flag.Float64("nf", 2.0, "receiver noise figure in dB (for -quantity margin)")
+	bw := flag.Float64("bw", 2500.0, "receiver bandwidth in Hz (for -quantity margin)")
+	snr := flag.Float64("snr", 10.0, "required SNR in dB (for -quantity margin)")
+	radius := flag.Float64("radius", 30.0, "how far out from the transmitter to go in km")
+	cell := flag.Int("cell", 3, "raster cell size in arcseconds")
+	spacing := flag.Float64("spacing", 0.1, "terrain sample spacing in km")
+	quantity := flag.String("quantity", "field", "what to map: field, power, margin or loss")
+	weak := flag.Float64("min", math.NaN(), "value drawn in blue (default depends on -quantity)")
+	strong := flag.Float64("max", math.NaN(), "value drawn in red (default depends on -quantity)")
+	out := flag.String("out", "coverage", "base name of the output files")
+	flag.Parse()
+
+	ll, err := location.Parse(*at)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	link := linkbudget.Link{TxPower: *power, TxLineLoss: *lineLoss, TxGain: *gain,
+		RxGain: *rxGain, RxLineLoss: *rxLineLoss, NoiseFigure: *nf, Bandwidth: *bw, RequiredSNR: *snr}
+	par := coverage.DefaultSignalParams(link, *freq, *height, *rxHeight, *radius)
+	par.CellArcSec, par.Spacing = *cell, *spacing
+	if *patName != "" {
+		pat, err := readPattern(*patName, *bearing)
+		if err != nil {
+			log.Fatal(err)
+		}
+		par.Pattern = pat
+	}
+
+	// the color scale runs from weak to strong signal
+	var lo, hi float64
+	switch *quantity {
+	case "field":
+		par.Quantity, lo, hi = coverage.FieldStrength, 0.0, 80.0
+	case "power":
+		par.Quantity, lo, hi = coverage.RxPower, -140.0, -60.0
+	case "margin":
+		par.Quantity, lo, hi = coverage.Margin, -20.0, 40.0
+	case "loss":
+		par.Quantity, lo, hi = coverage.PathLoss, 180.0, 80.0
+	default:
+		log.Fatalf("Unknown quantity %q: use field, power, margin or loss", *quantity)
+	}
+	if !math.IsNaN(*weak) {
+		lo = *weak
+	}
+	if !math.IsNaN(*strong) {
+		hi = *strong
+	}
+
+	store := nedmap.NewTileStore(*tiles, nedmap.DefaultCacheBudget)
+	ras, err := coverage.SignalMap(store, ll, par)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ras.WriteGridFloat(*out); err != nil {
+		log.Fatal(err)
+	}
+	if err := ras.WritePNG(*out, coverage.DefaultColorMap(lo, hi)); err != nil {
+		log.Fatal(err)
+	}
+
+	sw, ne := ras.Bounds()
+	fmt.Printf("# %s from %s, %.1f MHz, %d x %d cells of %d\"\n", par.Quantity, location.FormatDMS(ll, 1),
+		*freq, ras.Cols, ras.Rows, par.CellArcSec)
+	fmt.Printf("# bounds %s to %s\n", location.FormatDecimal(sw, 5), location.FormatDecimal(ne, 5))
+	fmt.Printf("# wrote %s.flt %s.hdr %s.png %s.pgw\n", *out, *out, *out, *out)
+}
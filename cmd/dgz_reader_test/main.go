@@ -13,7 +13,8 @@ import _ "net/http/pprof"
 
 
 func doWork(i int) (int) {
-	mcc,_ := nedmap.ReadZCompressedMap(os.Args[1])
+	mcc, err := nedmap.ReadZCompressedMap(os.Args[1])
+	if err != nil { log.Fatal(err) }
 	i++
 	if i > 3600 { i = 1 }
 	fmt.Println(mcc.Elevation[i][i])
@@ -0,0 +1,97 @@
+/*
+Copyright (c) 2012, Matthew H. Reilly (kb1vc)
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are
+met:
+
+    Redistributions of source code must retain the above copyright
+    notice, this list of conditions and the following disclaimer.
+    Redistributions in binary form must reproduce the above copyright
+    notice, this list of conditions and the following disclaimer in
+    the documentation and/or other materials provided with the
+    distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+"AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+// Print the terrain horizon all the way around a station site: the
+// elevation angle of the horizon at each azimuth step, from the map
+// tiles with the earth's curvature accounted for. Useful for knowing
+// how low the moon (or a satellite) can be and still be workable.
+//
+//	horizon_profile -tiles /data/ned -at FN42bl -height 10 -step 0.5 -range 50 -png horizon.png
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image/png"
+	"log"
+	"os"
+
+	"github.com/kb1vc/radiopath/location"
+	"github.com/kb1vc/radiopath/nedmap"
+	"github.com/kb1vc/radiopath/terrain"
+)
+
+func main() {
+	tiles := flag.String("tiles", ".", "directory of compressed map tiles (N43W072.dgz ...)")
+	at := flag.String("at", "", "station location: grid, lat,lon, DMS, UTM or MGRS")
+	height := flag.Float64("height", 10.0, "antenna height above ground in meters")
+	step := flag.Float64("step", 1.0, "azimuth step in degrees")
+	maxDist := flag.Float64("range", 50.0, "how far out to look for the horizon in km")
+	spacing := flag.Float64("spacing", 0.05, "terrain sample spacing in km")
+	k := flag.Float64("k", terrain.DefaultK, "effective earth radius factor")
+	pngName := flag.String("png", "", "also draw a polar plot of the horizon to this PNG file")
+	size := flag.Int("size", 600, "size of the polar plot in pixels")
+	flag.Parse()
+
+	ll, err := location.Parse(*at)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	store := nedmap.NewTileStore(*tiles, nedmap.DefaultCacheBudget)
+	ground, err := store.ElevationAt(ll)
+	if err != nil {
+		log.Fatal(err)
+	}
+	hor, err := terrain.HorizonProfile(store, ll, *height, *step, *maxDist, *spacing, *k)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	grid, _ := location.ToGrid(ll, 6)
+	fmt.Printf("# horizon from %s (%s) antenna %.1f m above ground %.1f m, k = %.3f, range %.1f km\n",
+		location.FormatDMS(ll, 1), grid, *height, ground, *k, *maxDist)
+	fmt.Printf("# %8s %10s %10s %12s %12s %10s\n", "azimuth", "angle", "dist_km", "lat", "lon", "elev_m")
+	for _, h := range hor {
+		fmt.Printf("  %8.2f %10.3f %10.3f %12.6f %12.6f %10.1f\n",
+			h.Azimuth, h.Angle, h.Point.Dist, h.Point.Pos.Lat, h.Point.Pos.Lon, h.Point.Elevation)
+	}
+
+	if *pngName != "" {
+		f, err := os.Create(*pngName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := png.Encode(f, polarPlot(hor, *size)); err != nil {
+			log.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
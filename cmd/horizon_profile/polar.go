@@ -0,0 +1,115 @@
+/*
+Copyright (c) 2012, Matthew H. Reilly (kb1vc)
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are
+met:
+
+    Redistributions of source code must retain the above copyright
+    notice, this list of conditions and the following disclaimer.
+    Redistributions in binary form must reproduce the above copyright
+    notice, this list of conditions and the following disclaimer in
+    the documentation and/or other materials provided with the
+    distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+"AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/kb1vc/radiopath/location"
+	"github.com/kb1vc/radiopath/terrain"
+)
+
+var (
+	skyColor     = color.RGBA{0xe8, 0xf0, 0xff, 0xff}
+	terrainColor = color.RGBA{0x6b, 0x8e, 0x23, 0xff}
+	ringColor    = color.RGBA{0x80, 0x80, 0x80, 0xff}
+	zeroColor    = color.RGBA{0x20, 0x20, 0xc0, 0xff}
+	outsideColor = color.RGBA{0xff, 0xff, 0xff, 0xff}
+)
+
+// Draw the horizon as a polar plot, north up and east to the right.
+// The center is the highest elevation angle on the plot and the rim
+// the lowest, with a ring every degree (the 0 degree ring in blue);
+// terrain is filled in from the rim up to the horizon.
+func polarPlot(hor []terrain.Horizon, size int) image.Image {
+	lo, hi := 0.0, 0.0
+	for _, h := range hor {
+		lo = math.Min(lo, h.Angle)
+		hi = math.Max(hi, h.Angle)
+	}
+	lo = math.Floor(lo) - 1.0
+	hi = math.Ceil(hi) + 1.0
+
+	// the horizon angle at each azimuth, which are ascending from north
+	azimuths := make([]float64, len(hor))
+	angles := make([]float64, len(hor))
+	for i, h := range hor {
+		azimuths[i], angles[i] = h.Azimuth, h.Angle
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	c := float64(size) / 2.0
+	rmax := c - 2.0
+
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			dx, dy := float64(x)+0.5-c, c-(float64(y)+0.5)
+			r := math.Hypot(dx, dy)
+			if r > rmax {
+				img.Set(x, y, outsideColor)
+				continue
+			}
+			el := hi - (hi-lo)*r/rmax
+			az := math.Mod(math.Atan2(dx, dy)*180.0/math.Pi+360.0, 360.0)
+
+			limit := location.InterpolateAzimuth(azimuths, angles, az)
+
+			// the rings: one pixel wide at every whole degree
+			dr := rmax / (hi - lo)
+			ring := math.Abs(el-math.Round(el))*dr < 0.5
+			switch {
+			case ring && math.Round(el) == 0.0:
+				img.Set(x, y, zeroColor)
+			case ring || spoke(dx, dy, r):
+				img.Set(x, y, ringColor)
+			case el < limit:
+				img.Set(x, y, terrainColor)
+			default:
+				img.Set(x, y, skyColor)
+			}
+		}
+	}
+	return img
+}
+
+// true for pixels on the azimuth spokes every 30 degrees
+func spoke(dx, dy, r float64) bool {
+	for a := 0.0; a < 360.0; a += 30.0 {
+		s, c := math.Sincos(a * math.Pi / 180.0)
+		// distance from the pixel to the spoke, along it only
+		along := dx*s + dy*c
+		across := dx*c - dy*s
+		if (along > 0.0) && (math.Abs(across) < 0.5) && (r > 0.0) {
+			return true
+		}
+	}
+	return false
+}
@@ -16,7 +16,8 @@ func main() {
 
 	// now check the map
 	zreadStart := time.Now()
-	mcc,_ := nedmap.ReadZCompressedMap(cmpfname)
+	mcc, zerr := nedmap.ReadZCompressedMap(cmpfname)
+	if zerr != nil { panic(zerr) }
 	zreadElapsed := time.Since(zreadStart)
 	fmt.Printf("Compressed readtime: %s\n", zreadElapsed)
 
@@ -31,32 +31,383 @@ OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 
 // server that accepts requests with from/to location
 // and produces a "slice of the earth" radio path model
+//
+//	radiopath-server -addr :8080 -tiles /data/ned
+//
+// GET /path?from=FN42bl&to=42.97,-72.25&txheight=10&rxheight=10&freq=144
+//
+// "from" and "to" may be anything location.Parse accepts: Maidenhead
+// grid locators, "lat,lon" in decimal degrees, DMS, UTM or MGRS.
+// Antenna heights are meters above ground, the frequency is in MHz.
+//
+// Add txpower=<dBm> (and optionally txgain, rxgain, txloss, rxloss in
+// dB, nf in dB, bw in Hz and snr in dB) for a link budget based on the
+// ITM path loss.
+//
+// GET /horizon?at=FN42bl&height=10&az=270
+//
+// returns the smooth-earth radio horizon of an antenna "height" meters
+// above the ground at "at" and, given an azimuth "az", the
+// terrain-limited horizon in that direction (searched out to "range"
+// km, twice the smooth-earth horizon by default). "k" sets the
+// effective earth radius factor.
 package main
 
 import (
-	"github.com/kb1vc/radiopath/location"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/kb1vc/radiopath/itm"
+	"github.com/kb1vc/radiopath/linkbudget"
+	"github.com/kb1vc/radiopath/location"
+	"github.com/kb1vc/radiopath/nedmap"
+	"github.com/kb1vc/radiopath/terrain"
 )
 
-func main() {
-	fmt.Println("Hello World")
-	ll,err := location.FromGrid("FN42bl")
+type pathServer struct {
+	store   *nedmap.TileStore
+	spacing float64 // profile sample spacing in km
+}
+
+type latLonJSON struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+type profileJSON struct {
+	Dist      float64 `json:"dist_km"`
+	Lat       float64 `json:"lat"`
+	Lon       float64 `json:"lon"`
+	Elevation float64 `json:"elevation_m"`
+}
+
+type losJSON struct {
+	Clear     bool    `json:"clear"`
+	Clearance float64 `json:"clearance_m"`
+	WorstDist float64 `json:"worst_dist_km"`
+}
+
+type lossJSON struct {
+	Loss          float64 `json:"loss_db"`
+	MedianLoss    float64 `json:"median_loss_db"`
+	FreeSpaceLoss float64 `json:"free_space_loss_db"`
+	Diffraction   float64 `json:"diffraction_loss_db"`
+	TwoRay        float64 `json:"two_ray_loss_db"`
+	Mode          string  `json:"mode"`
+	Warning       int     `json:"itm_warning"`
+}
+
+type budgetJSON struct {
+	EIRP       float64 `json:"eirp_dbm"`
+	RxPower    float64 `json:"rx_power_dbm"`
+	NoiseFloor float64 `json:"noise_floor_dbm"`
+	SNR        float64 `json:"snr_db"`
+	Margin     float64 `json:"margin_db"`
+}
+
+type pathJSON struct {
+	From           latLonJSON    `json:"from"`
+	To             latLonJSON    `json:"to"`
+	FromGrid       string        `json:"from_grid"`
+	ToGrid         string        `json:"to_grid"`
+	Bearing        float64       `json:"bearing"`
+	ReverseBearing float64       `json:"reverse_bearing"`
+	Distance       float64       `json:"distance_km"`
+	TxHeight       float64       `json:"tx_height_m"`
+	RxHeight       float64       `json:"rx_height_m"`
+	FreqMHz        float64       `json:"freq_mhz"`
+	LOS            losJSON       `json:"line_of_sight"`
+	Loss           *lossJSON     `json:"loss,omitempty"`
+	Budget         *budgetJSON   `json:"budget,omitempty"`
+	Profile        []profileJSON `json:"profile"`
+}
+
+type terrainHorizonJSON struct {
+	Azimuth   float64 `json:"azimuth"`
+	Dist      float64 `json:"dist_km"`
+	Lat       float64 `json:"lat"`
+	Lon       float64 `json:"lon"`
+	Elevation float64 `json:"elevation_m"`
+	Angle     float64 `json:"angle_deg"`
+}
+
+type horizonJSON struct {
+	At         latLonJSON          `json:"at"`
+	Grid       string              `json:"grid"`
+	Height     float64             `json:"height_m"`
+	Ground     float64             `json:"ground_m"`
+	K          float64             `json:"k"`
+	Smooth     float64             `json:"smooth_horizon_km"`
+	TerrainLim *terrainHorizonJSON `json:"terrain_horizon,omitempty"`
+}
+
+type errorJSON struct {
+	Error string `json:"error"`
+}
+
+// Return the float value of query parameter "name", or def if it is absent.
+func floatParam(r *http.Request, name string, def float64) (float64, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return def, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0.0, errors.New(fmt.Sprintf("Bad value for %s: %q", name, s))
+	}
+	return v, nil
+}
+
+// Send v as the JSON body of a response with the given status. The
+// body is encoded before anything is sent, so that a value that can't
+// be encoded becomes a 500 rather than an empty response.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		log.Println(err)
+		status = http.StatusInternalServerError
+		body, _ = json.Marshal(errorJSON{Error: "Can't encode the response: " + err.Error()})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorJSON{Error: err.Error()})
+}
+
+// Collect the link budget parameters from the query.
+func linkParams(r *http.Request) (linkbudget.Link, error) {
+	var l linkbudget.Link
+	params := []struct {
+		name string
+		v    *float64
+		def  float64
+	}{
+		{"txpower", &l.TxPower, 0.0},
+		{"txloss", &l.TxLineLoss, 0.0},
+		{"txgain", &l.TxGain, 0.0},
+		{"rxgain", &l.RxGain, 0.0},
+		{"rxloss", &l.RxLineLoss, 0.0},
+		{"nf", &l.NoiseFigure, 2.0},
+		{"bw", &l.Bandwidth, 2500.0},
+		{"snr", &l.RequiredSNR, 10.0},
+	}
+	for _, p := range params {
+		v, err := floatParam(r, p.name, p.def)
+		if err != nil {
+			return l, err
+		}
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return l, fmt.Errorf("%s must be a finite number, got %g", p.name, v)
+		}
+		*p.v = v
+	}
+	return l, nil
+}
+
+// Reject /path parameters that the models can't work with: antenna
+// heights must be finite and not negative, and a frequency, if one is
+// given, finite and positive.
+func checkPathParams(txHeight, rxHeight, freq float64, haveFreq bool) error {
+	for _, h := range []float64{txHeight, rxHeight} {
+		if !(h >= 0.0) || math.IsInf(h, 0) {
+			return fmt.Errorf("Antenna heights must be zero or more meters, got %g", h)
+		}
+	}
+	if haveFreq && (!(freq > 0.0) || math.IsInf(freq, 0)) {
+		return fmt.Errorf("Frequency must be a positive number of MHz, got %g", freq)
+	}
+	return nil
+}
+
+func (s *pathServer) handlePath(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, err := location.Parse(q.Get("from"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	to, err := location.Parse(q.Get("to"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var ret pathJSON
+	var perr error
+	ret.TxHeight, perr = floatParam(r, "txheight", 10.0)
+	if perr == nil {
+		ret.RxHeight, perr = floatParam(r, "rxheight", 10.0)
+	}
+	if perr == nil {
+		ret.FreqMHz, perr = floatParam(r, "freq", 0.0)
+	}
+	if perr == nil {
+		perr = checkPathParams(ret.TxHeight, ret.RxHeight, ret.FreqMHz, q.Get("freq") != "")
+	}
+	if perr != nil {
+		writeError(w, http.StatusBadRequest, perr)
+		return
+	}
+
+	ret.From = latLonJSON{from.Lat, from.Lon}
+	ret.To = latLonJSON{to.Lat, to.Lon}
+	ret.FromGrid, _ = location.ToGrid(from, 6)
+	ret.ToGrid, _ = location.ToGrid(to, 6)
+	ret.Bearing, ret.ReverseBearing, ret.Distance = from.Bearing(to)
+
+	prof, err := nedmap.GetProfile(s.store, from, to, s.spacing)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	ret.Profile = make([]profileJSON, len(prof))
+	for i, p := range prof {
+		ret.Profile[i] = profileJSON{p.Dist, p.Pos.Lat, p.Pos.Lon, p.Elevation}
+	}
+
+	los, err := terrain.LineOfSight(prof, ret.TxHeight, ret.RxHeight, terrain.DefaultK)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	ret.LOS = losJSON{Clear: los.Clear, Clearance: los.Clearance, WorstDist: los.Point.Dist}
+	if los.Worst < 0 {
+		ret.LOS.Clearance = 0.0
+	}
+
+	if ret.FreqMHz > 0.0 {
+		res, err := itm.PointToPoint(prof, itm.DefaultParams(ret.FreqMHz, ret.TxHeight, ret.RxHeight))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		dif, err := terrain.DiffractionLoss(prof, ret.TxHeight, ret.RxHeight, ret.FreqMHz, terrain.DefaultK, terrain.Deygout)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		tworay, _, err := linkbudget.TwoRayProfileLoss(prof, ret.TxHeight, ret.RxHeight, ret.FreqMHz)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		ret.Loss = &lossJSON{Loss: res.Loss, MedianLoss: res.MedianLoss, FreeSpaceLoss: res.FreeSpaceLoss,
+			Diffraction: dif.Loss, TwoRay: tworay, Mode: res.Mode.String(), Warning: res.Warning}
+
+		if q.Get("txpower") != "" {
+			link, err := linkParams(r)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			b, err := link.Budget(res.Loss)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			ret.Budget = &budgetJSON{EIRP: b.EIRP, RxPower: b.RxPower, NoiseFloor: b.NoiseFloor,
+				SNR: b.SNR, Margin: b.Margin}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, ret)
+}
+
+// Reject /horizon parameters that would make the horizon NaN or
+// infinite (those cannot be encoded as JSON) or meaningless.
+func checkHorizonParams(height, k, az, maxDist float64, haveRange bool) error {
+	if !(height >= 0.0) || math.IsInf(height, 0) {
+		return fmt.Errorf("Antenna height must be zero or more meters, got %g", height)
+	}
+	if !(k > 0.0) || math.IsInf(k, 0) {
+		return fmt.Errorf("Effective earth radius factor k must be positive, got %g", k)
+	}
+	if math.IsNaN(az) || math.IsInf(az, 0) {
+		return fmt.Errorf("Azimuth must be a finite number, got %g", az)
+	}
+	if haveRange && (!(maxDist > 0.0) || math.IsInf(maxDist, 0)) {
+		return fmt.Errorf("Horizon search range must be a positive number of km, got %g", maxDist)
+	}
+	return nil
+}
+
+func (s *pathServer) handleHorizon(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	at, err := location.Parse(q.Get("at"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var ret horizonJSON
+	var az, maxDist float64
+	var perr error
+	ret.Height, perr = floatParam(r, "height", 10.0)
+	if perr == nil {
+		ret.K, perr = floatParam(r, "k", terrain.DefaultK)
+	}
+	if perr == nil {
+		az, perr = floatParam(r, "az", 0.0)
+	}
+	if perr == nil {
+		maxDist, perr = floatParam(r, "range", 0.0)
+	}
+	if perr == nil {
+		perr = checkHorizonParams(ret.Height, ret.K, az, maxDist, q.Get("range") != "")
+	}
+	if perr != nil {
+		writeError(w, http.StatusBadRequest, perr)
+		return
+	}
+
+	ret.At = latLonJSON{at.Lat, at.Lon}
+	ret.Grid, _ = location.ToGrid(at, 6)
+	ret.Ground, err = s.store.ElevationAt(at)
 	if err == nil {
-		fmt.Println(ll)
-	} else {
-		fmt.Println(err)
+		ret.Smooth, err = terrain.StationHorizon(s.store, at, ret.Height, ret.K)
+	}
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
 	}
 
-	fmt.Println(location.ToGrid(ll))
+	if q.Get("az") != "" {
+		if q.Get("range") == "" {
+			maxDist = 2.0*ret.Smooth + s.spacing
+		}
+		h, err := terrain.TerrainHorizon(s.store, at, ret.Height, az, maxDist, s.spacing, ret.K)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		ret.TerrainLim = &terrainHorizonJSON{Azimuth: h.Azimuth, Dist: h.Point.Dist,
+			Lat: h.Point.Pos.Lat, Lon: h.Point.Pos.Lon, Elevation: h.Point.Elevation, Angle: h.Angle}
+	}
+
+	writeJSON(w, http.StatusOK, ret)
+}
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	tiles := flag.String("tiles", ".", "directory of compressed map tiles (N43W072.dgz ...)")
+	cacheMB := flag.Int64("cache", 1024, "memory budget for decoded map tiles in MB")
+	spacing := flag.Float64("spacing", 0.1, "profile sample spacing in km")
+	flag.Parse()
 
-	wach, _ := location.FromGrid("FM19ng")
-	wash, _ := location.FromGrid("FN44ig")
-	fmt.Println(wash)
+	s := &pathServer{store: nedmap.NewTileStore(*tiles, *cacheMB<<20), spacing: *spacing}
 
-	fmt.Println(wach.Bearing(wash))
-	fmt.Println(wash.Bearing(wach))
+	http.HandleFunc("/path", s.handlePath)
+	http.HandleFunc("/horizon", s.handleHorizon)
 
-	brg, _, dist := wach.Bearing(wash)
-	
-	fmt.Println(wach.OnPath(brg, dist))
+	log.Printf("radiopath-server listening on %s, tiles from %s\n", *addr, *tiles)
+	log.Fatal(http.ListenAndServe(*addr, nil))
 }
@@ -0,0 +1,99 @@
+/*
+Copyright (c) 2012, Matthew H. Reilly (kb1vc)
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are
+met:
+
+    Redistributions of source code must retain the above copyright
+    notice, this list of conditions and the following disclaimer.
+    Redistributions in binary form must reproduce the above copyright
+    notice, this list of conditions and the following disclaimer in
+    the documentation and/or other materials provided with the
+    distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+"AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+// Check compressed map tiles for damage: decode every row of every
+// tile, test the checksums, and report the tiles that are bad. Tiles
+// written before checksums were added to the format can only be checked
+// for a sound structure; they are listed so they can be converted again.
+// Exits with status 1 if any tile is damaged.
+//
+//	verify_tiles -tiles /data/ned
+//	verify_tiles N43W072.dgz N42W072.dgz
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/kb1vc/radiopath/nedmap"
+)
+
+func main() {
+	tiles := flag.String("tiles", ".", "directory of compressed map tiles to check, if none are named")
+	verbose := flag.Bool("v", false, "list the sound tiles too, with their digests")
+	flag.Parse()
+
+	names := flag.Args()
+	if len(names) == 0 {
+		var err error
+		names, err = filepath.Glob(filepath.Join(*tiles, "*.dgz"))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	damaged, unchecked := 0, 0
+	for _, name := range names {
+		integ, err := nedmap.VerifyMap(name)
+		if err != nil {
+			// the file name is already in the first column
+			var ferr *nedmap.FormatError
+			if errors.As(err, &ferr) {
+				ferr.File = ""
+			}
+			fmt.Printf("%-16s %04x DAMAGED: %s\n", filepath.Base(name), integ.Version, err)
+			damaged++
+			continue
+		}
+		if !integ.Checksummed {
+			fmt.Printf("%-16s %04x ok, but has no checksums\n", filepath.Base(name), integ.Version)
+			unchecked++
+			continue
+		}
+		if *verbose {
+			fmt.Printf("%-16s %04x ok", filepath.Base(name), integ.Version)
+			if integ.Digest != nil {
+				fmt.Printf(" sha256 %x", integ.Digest)
+			}
+			fmt.Printf("\n\t%s", integ.Info.Name())
+			if vd := integ.Info.VerticalDatum(); vd != "" {
+				fmt.Printf(", %s", vd)
+			}
+			fmt.Printf(", elevations in %s\n", integ.Info.Units())
+		}
+	}
+
+	fmt.Printf("# %d tiles, %d damaged, %d without checksums\n", len(names), damaged, unchecked)
+	if damaged > 0 {
+		os.Exit(1)
+	}
+}
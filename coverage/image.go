@@ -0,0 +1,127 @@
+/*
+Copyright (c) 2012, Matthew H. Reilly (kb1vc)
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are
+met:
+
+    Redistributions of source code must retain the above copyright
+    notice, this list of conditions and the following disclaimer.
+    Redistributions in binary form must reproduce the above copyright
+    notice, this list of conditions and the following disclaimer in
+    the documentation and/or other materials provided with the
+    distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+"AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package coverage
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"sort"
+)
+
+// A value and the color it is drawn in.
+type ColorStop struct {
+	Value float64
+	Color color.RGBA
+}
+
+// A color scale for drawing rasters: values between stops are blended
+// linearly, values beyond the ends take the end colors.
+type ColorMap []ColorStop
+
+// Return the usual signal strength scale, running from blue at weak
+// through cyan, green and yellow to red at strong. weak may be larger
+// than strong, as for path loss where less is better.
+func DefaultColorMap(weak, strong float64) ColorMap {
+	colors := []color.RGBA{
+		{0, 0, 255, 255},
+		{0, 255, 255, 255},
+		{0, 255, 0, 255},
+		{255, 255, 0, 255},
+		{255, 0, 0, 255},
+	}
+	ret := make(ColorMap, len(colors))
+	for i, c := range colors {
+		ret[i] = ColorStop{Value: weak + (strong-weak)*float64(i)/float64(len(colors)-1), Color: c}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Value < ret[j].Value })
+	return ret
+}
+
+// Return the color for value v. The stops must be in increasing order
+// of value.
+func (cm ColorMap) Color(v float64) color.RGBA {
+	n := len(cm)
+	if n == 0 {
+		return color.RGBA{}
+	}
+	if v <= cm[0].Value {
+		return cm[0].Color
+	}
+	if v >= cm[n-1].Value {
+		return cm[n-1].Color
+	}
+	i := sort.Search(n, func(i int) bool { return cm[i].Value > v })
+	lo, hi := cm[i-1], cm[i]
+	f := (v - lo.Value) / (hi.Value - lo.Value)
+	blend := func(a, b uint8) uint8 { return uint8(math.Floor(float64(a)*(1.0-f) + float64(b)*f + 0.5)) }
+	return color.RGBA{blend(lo.Color.R, hi.Color.R), blend(lo.Color.G, hi.Color.G),
+		blend(lo.Color.B, hi.Color.B), blend(lo.Color.A, hi.Color.A)}
+}
+
+// Draw the raster, one pixel per cell, north up. Cells with no value
+// are transparent.
+func (r *Raster) Image(cm ColorMap) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, r.Cols, r.Rows))
+	for i := 0; i < r.Rows; i++ {
+		for j := 0; j < r.Cols; j++ {
+			v := float64(r.At(i, j))
+			if math.IsNaN(v) {
+				continue
+			}
+			c := cm.Color(v)
+			img.SetNRGBA(j, i, color.NRGBA{c.R, c.G, c.B, c.A})
+		}
+	}
+	return img
+}
+
+// Write the world file that georeferences an image of the raster: the
+// pixel size and the center of the upper left pixel, in degrees.
+func (r *Raster) WriteWorldFile(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "%.12f\n0.0\n0.0\n%.12f\n%.10f\n%.10f\n",
+		r.Cell, -r.Cell, r.West+0.5*r.Cell, r.North-0.5*r.Cell)
+	return err
+}
+
+// Draw the raster to base.png with the world file base.pgw beside
+// it, so GIS tools can overlay it on a map.
+func (r *Raster) WritePNG(base string, cm ColorMap) error {
+	if len(cm) == 0 {
+		return errors.New("A color map needs at least one stop")
+	}
+	if err := writeFile(base+".pgw", r.WriteWorldFile); err != nil {
+		return err
+	}
+	return writeFile(base+".png", func(w io.Writer) error { return png.Encode(w, r.Image(cm)) })
+}
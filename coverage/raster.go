@@ -0,0 +1,181 @@
+/*
+Copyright (c) 2012, Matthew H. Reilly (kb1vc)
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are
+met:
+
+    Redistributions of source code must retain the above copyright
+    notice, this list of conditions and the following disclaimer.
+    Redistributions in binary form must reproduce the above copyright
+    notice, this list of conditions and the following disclaimer in
+    the documentation and/or other materials provided with the
+    distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+"AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+// Area coverage over terrain: viewsheds and predicted signal
+// strength maps, computed on rasters aligned with the nedmap tiles.
+package coverage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/kb1vc/radiopath/location"
+)
+
+// The size in degrees of a cell in the 1 arcsecond NED tiles.
+const ArcSecond = 1.0 / 3600.0
+
+// The value written for cells with no data in GridFloat files.
+const NoData = -9999.0
+
+// A georeferenced grid of values, laid out like the NED tiles: pixel
+// is area, row 0 at the north edge. Cell (r, c) covers latitudes
+// [North - (r+1)*Cell, North - r*Cell) and longitudes
+// [West + c*Cell, West + (c+1)*Cell). Cells with no value hold NaN.
+type Raster struct {
+	North float64 // latitude of the north edge in degrees
+	West  float64 // longitude of the west edge in degrees
+	Cell  float64 // cell size in degrees
+	Rows  int
+	Cols  int
+	Data  []float32 // Rows x Cols values, row by row from the north
+}
+
+// Create a raster covering the box with corners sw and ne, with cells
+// cellArcSec arcseconds on a side. The edges are moved out to whole
+// multiples of the cell size so that the cells line up with the
+// cells of the map tiles. All cells start out with no data.
+func NewRaster(sw, ne location.LatLon, cellArcSec int) (*Raster, error) {
+	if cellArcSec < 1 {
+		return nil, errors.New(fmt.Sprintf("Raster cell size must be at least 1 arcsecond, got %d", cellArcSec))
+	}
+	if (sw.Lat >= ne.Lat) || (sw.Lon >= ne.Lon) {
+		return nil, errors.New(fmt.Sprintf("Raster box [%f %f] to [%f %f] is empty", sw.Lat, sw.Lon, ne.Lat, ne.Lon))
+	}
+
+	// snap in whole cells, counted in arcseconds to keep the edges exact
+	cs := float64(cellArcSec)
+	north := math.Ceil(ne.Lat*3600.0/cs) * cs
+	south := math.Floor(sw.Lat*3600.0/cs) * cs
+	west := math.Floor(sw.Lon*3600.0/cs) * cs
+	east := math.Ceil(ne.Lon*3600.0/cs) * cs
+
+	r := &Raster{North: north / 3600.0, West: west / 3600.0, Cell: cs / 3600.0,
+		Rows: int(math.Round((north - south) / cs)), Cols: int(math.Round((east - west) / cs))}
+	r.Data = make([]float32, r.Rows*r.Cols)
+	r.Fill(float32(math.NaN()))
+	return r, nil
+}
+
+// Set every cell to v.
+func (r *Raster) Fill(v float32) {
+	for i := range r.Data {
+		r.Data[i] = v
+	}
+}
+
+// Return the value at row, col.
+func (r *Raster) At(row, col int) float32 {
+	return r.Data[row*r.Cols+col]
+}
+
+// Set the value at row, col.
+func (r *Raster) Set(row, col int, v float32) {
+	r.Data[row*r.Cols+col] = v
+}
+
+// Return the row and column of the cell containing ll, and
+// false if ll is outside the raster.
+func (r *Raster) Index(ll location.LatLon) (int, int, bool) {
+	row := int(math.Floor((r.North - ll.Lat) / r.Cell))
+	col := int(math.Floor((ll.Lon - r.West) / r.Cell))
+	if (row < 0) || (row >= r.Rows) || (col < 0) || (col >= r.Cols) {
+		return 0, 0, false
+	}
+	return row, col, true
+}
+
+// Return the location of the center of the cell at row, col.
+func (r *Raster) Center(row, col int) location.LatLon {
+	return location.LatLon{Lat: r.North - (float64(row)+0.5)*r.Cell, Lon: r.West + (float64(col)+0.5)*r.Cell}
+}
+
+// Return the south-west and north-east corners of the raster.
+func (r *Raster) Bounds() (location.LatLon, location.LatLon) {
+	return location.LatLon{Lat: r.North - float64(r.Rows)*r.Cell, Lon: r.West},
+		location.LatLon{Lat: r.North, Lon: r.West + float64(r.Cols)*r.Cell}
+}
+
+// Write the cell values as a GridFloat file (the format of the USGS
+// NED .flt files): little endian float32, row by row from the north,
+// with NoData for cells that have no value.
+func (r *Raster) WriteFloat(w io.Writer) error {
+	row := make([]float32, r.Cols)
+	for i := 0; i < r.Rows; i++ {
+		for j := range row {
+			v := r.Data[i*r.Cols+j]
+			if math.IsNaN(float64(v)) {
+				v = NoData
+			}
+			row[j] = v
+		}
+		if err := binary.Write(w, binary.LittleEndian, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write the ESRI header that georeferences a GridFloat file.
+func (r *Raster) WriteHeader(w io.Writer) error {
+	sw, _ := r.Bounds()
+	_, err := fmt.Fprintf(w, "ncols %d\nnrows %d\nxllcorner %.10f\nyllcorner %.10f\ncellsize %.12f\nNODATA_value %g\nbyteorder LSBFIRST\n",
+		r.Cols, r.Rows, sw.Lon, sw.Lat, r.Cell, NoData)
+	return err
+}
+
+// Write the raster to base.flt and base.hdr, a GridFloat pair
+// that GIS tools read directly.
+func (r *Raster) WriteGridFloat(base string) error {
+	if err := writeFile(base+".hdr", r.WriteHeader); err != nil {
+		return err
+	}
+	return writeFile(base+".flt", r.WriteFloat)
+}
+
+func writeFile(name string, write func(io.Writer) error) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	if err := write(bw); err != nil {
+		f.Close()
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
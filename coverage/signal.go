@@ -0,0 +1,293 @@
+/*
+Copyright (c) 2012, Matthew H. Reilly (kb1vc)
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are
+met:
+
+    Redistributions of source code must retain the above copyright
+    notice, this list of conditions and the following disclaimer.
+    Redistributions in binary form must reproduce the above copyright
+    notice, this list of conditions and the following disclaimer in
+    the documentation and/or other materials provided with the
+    distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+"AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package coverage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/kb1vc/radiopath/itm"
+	"github.com/kb1vc/radiopath/linkbudget"
+	"github.com/kb1vc/radiopath/location"
+	"github.com/kb1vc/radiopath/nedmap"
+)
+
+// A transmitting antenna's horizontal radiation pattern.
+type Pattern interface {
+	// Return the gain toward azimuth az (degrees) in dB relative
+	// to the antenna's peak gain: 0 or less.
+	RelativeGain(az float64) float64
+}
+
+// A pattern given as relative gains in dB at a list of azimuths,
+// interpolated linearly in between, and turned clockwise by Bearing
+// degrees (the direction the antenna is pointed if the table has its
+// main lobe at 0).
+type TablePattern struct {
+	Azimuths []float64 // degrees, ascending, in [0, 360)
+	Gains    []float64 // dB relative to the peak
+	Bearing  float64
+}
+
+// Create a TablePattern from azimuth, gain pairs in any order.
+func NewTablePattern(az, gain []float64, bearing float64) (TablePattern, error) {
+	if (len(az) == 0) || (len(az) != len(gain)) {
+		return TablePattern{}, errors.New(fmt.Sprintf("An antenna pattern needs matching azimuths and gains, got %d and %d", len(az), len(gain)))
+	}
+	idx := make([]int, len(az))
+	for i := range idx {
+		idx[i] = i
+	}
+	norm := func(a float64) float64 { return math.Mod(math.Mod(a, 360.0)+360.0, 360.0) }
+	sort.Slice(idx, func(i, j int) bool { return norm(az[idx[i]]) < norm(az[idx[j]]) })
+
+	ret := TablePattern{Azimuths: make([]float64, len(az)), Gains: make([]float64, len(az)), Bearing: bearing}
+	for i, k := range idx {
+		ret.Azimuths[i], ret.Gains[i] = norm(az[k]), gain[k]
+	}
+	return ret, nil
+}
+
+func (p TablePattern) RelativeGain(az float64) float64 {
+	return location.InterpolateAzimuth(p.Azimuths, p.Gains, az-p.Bearing)
+}
+
+// What a signal map shows in each cell.
+type Quantity int
+
+const (
+	PathLoss      Quantity = iota // ITM path loss in dB
+	FieldStrength                 // field strength in dB(uV/m)
+	RxPower                       // power at the receiver input in dBm
+	Margin                        // SNR above the link's required SNR in dB
+)
+
+func (q Quantity) String() string {
+	switch q {
+	case PathLoss:
+		return "path loss (dB)"
+	case FieldStrength:
+		return "field strength (dBuV/m)"
+	case RxPower:
+		return "received power (dBm)"
+	case Margin:
+		return "margin (dB)"
+	}
+	return fmt.Sprintf("Quantity(%d)", int(q))
+}
+
+// What to compute a signal map for.
+type SignalParams struct {
+	Link       linkbudget.Link // transmitter and receiver; Link.TxGain is the peak antenna gain
+	Pattern    Pattern         // the transmitting antenna pattern, nil for omnidirectional
+	FreqMHz    float64
+	TxHeight   float64 // antenna height above ground at the site in meters
+	RxHeight   float64 // receiving antenna height above ground in meters
+	Radius     float64 // how far out from the site to go in km
+	Spacing    float64 // terrain profile sample spacing in km
+	CellArcSec int     // raster cell size in arcseconds
+	Quantity   Quantity
+	Model      *itm.Params // ITM settings; nil for itm.DefaultParams
+	Workers    int         // number of radials computed at once; 0 for one per CPU
+}
+
+// Return signal map parameters for field strength from the link's
+// transmitter with an omnidirectional antenna, at 3" cells with terrain
+// sampled every 100 m.
+func DefaultSignalParams(link linkbudget.Link, freqMHz, txHeight, rxHeight, radius float64) SignalParams {
+	return SignalParams{Link: link, FreqMHz: freqMHz, TxHeight: txHeight, RxHeight: rxHeight,
+		Radius: radius, Spacing: 0.1, CellArcSec: 3, Quantity: FieldStrength}
+}
+
+// Compute a signal map for a transmitter at site: for every cell within
+// par.Radius km, the par.Quantity predicted by ITM over the terrain
+// profile from the site to the cell. Cells beyond the radius, or whose
+// profile runs off the map data, have no value (NaN). Cells closer than
+// two profile samples to the site use free space loss.
+//
+// Like Viewshed, the map is built from radials out from the site, as
+// many as there are cells around the edge of the raster, each sampled
+// every par.Spacing km (or closer, if the cells are smaller). Each
+// radial's terrain is looked up once, and each cell is given the
+// prediction at the sample nearest to it on the radial nearest to it,
+// found with ITM over the radial's profile out to that sample. That
+// is one ITM run per cell (fewer near the site, where cells share
+// samples), each over as many samples as there are out to the cell:
+// the work grows as the cube of the radius in cells.
+// Radials are independent and are computed in parallel.
+func SignalMap(src nedmap.ElevationSource, site location.LatLon, par SignalParams) (*Raster, error) {
+	if !(par.Radius > 0.0) {
+		return nil, errors.New(fmt.Sprintf("Coverage radius must be positive, got %f", par.Radius))
+	}
+	if !(par.FreqMHz > 0.0) {
+		return nil, errors.New(fmt.Sprintf("Frequency must be positive, got %f MHz", par.FreqMHz))
+	}
+	if !(par.Spacing > 0.0) {
+		return nil, errors.New(fmt.Sprintf("Profile sample spacing must be positive, got %f", par.Spacing))
+	}
+	if (par.Quantity == Margin) && !(par.Link.Bandwidth > 0.0) {
+		return nil, errors.New(fmt.Sprintf("Receiver bandwidth must be positive, got %f Hz", par.Link.Bandwidth))
+	}
+	model := itm.DefaultParams(par.FreqMHz, par.TxHeight, par.RxHeight)
+	if par.Model != nil {
+		model = *par.Model
+		model.FreqMHz, model.TxHeight, model.RxHeight = par.FreqMHz, par.TxHeight, par.RxHeight
+	}
+	if _, err := src.ElevationAt(site); err != nil {
+		return nil, err
+	}
+
+	ras, err := circleRaster(site, par.Radius, par.CellArcSec)
+	if err != nil {
+		return nil, err
+	}
+
+	nsteps := int(math.Ceil(par.Radius / math.Min(par.Spacing, sampleSpacing(ras, site.Lat))))
+	step := par.Radius / float64(nsteps)
+	nrad := 2 * (ras.Rows + ras.Cols)
+	azstep := 360.0 / float64(nrad)
+
+	// the cells that take their value from each radial, and the sample
+	// on the radial that each is nearest to
+	type radialCell struct {
+		sample int
+		cell   int
+		dist   float64
+	}
+	cells := make([][]radialCell, nrad)
+	for row := 0; row < ras.Rows; row++ {
+		for col := 0; col < ras.Cols; col++ {
+			az, _, dist := site.Bearing(ras.Center(row, col))
+			if dist > par.Radius {
+				continue
+			}
+			rad := int(math.Floor(az/azstep+0.5)) % nrad
+			cells[rad] = append(cells[rad], radialCell{sample: int(math.Floor(dist/step + 0.5)),
+				cell: row*ras.Cols + col, dist: dist})
+		}
+	}
+
+	// the value at the end of prof, toward az
+	value := func(prof []nedmap.ProfilePoint, dist, az float64) float32 {
+		var loss float64
+		if len(prof) < 3 {
+			loss = linkbudget.FreeSpaceLoss(math.Max(dist, 0.001), par.FreqMHz)
+		} else {
+			res, err := itm.PointToPoint(prof, model)
+			if err != nil {
+				return float32(math.NaN())
+			}
+			loss = res.Loss
+		}
+		if par.Quantity == PathLoss {
+			return float32(loss)
+		}
+
+		link := par.Link
+		if par.Pattern != nil {
+			link.TxGain += par.Pattern.RelativeGain(az)
+		}
+		eirp := link.TxPower - link.TxLineLoss + link.TxGain
+		switch par.Quantity {
+		case FieldStrength:
+			return float32(linkbudget.FieldStrength(eirp, loss, par.FreqMHz))
+		case RxPower:
+			return float32(eirp - loss + link.RxGain - link.RxLineLoss)
+		}
+		b, _ := link.Budget(loss)
+		return float32(b.Margin)
+	}
+
+	radial := func(rad int) {
+		rc := cells[rad]
+		if len(rc) == 0 {
+			return
+		}
+		sort.Slice(rc, func(i, j int) bool { return rc[i].sample < rc[j].sample })
+		az := azstep * float64(rad)
+		pts, err := location.NewPath(site, site.OnPath(az, par.Radius), location.ShortPath).Points(nsteps + 1)
+		if err != nil {
+			return
+		}
+
+		// the terrain out to the furthest sample needed, or to the
+		// edge of the map data
+		prof := make([]nedmap.ProfilePoint, 0, rc[len(rc)-1].sample+1)
+		for _, pt := range pts[:rc[len(rc)-1].sample+1] {
+			el, err := src.ElevationAt(pt.Pos)
+			if err != nil {
+				break
+			}
+			prof = append(prof, nedmap.ProfilePoint{Dist: pt.Dist, Pos: pt.Pos, Elevation: el})
+		}
+
+		prev, v := -1, float32(0.0)
+		for _, c := range rc {
+			switch {
+			case c.sample >= len(prof):
+				return
+			case c.sample < 2:
+				// free space, over the cell's own distance
+				ras.Data[c.cell] = value(prof[:c.sample+1], c.dist, az)
+			default:
+				if c.sample != prev {
+					prev, v = c.sample, value(prof[:c.sample+1], c.dist, az)
+				}
+				ras.Data[c.cell] = v
+			}
+		}
+	}
+
+	workers := par.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	next := make(chan int, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rad := range next {
+				radial(rad)
+			}
+		}()
+	}
+	for rad := 0; rad < nrad; rad++ {
+		next <- rad
+	}
+	close(next)
+	wg.Wait()
+
+	return ras, nil
+}
@@ -0,0 +1,197 @@
+/*
+Copyright (c) 2012, Matthew H. Reilly (kb1vc)
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are
+met:
+
+    Redistributions of source code must retain the above copyright
+    notice, this list of conditions and the following disclaimer.
+    Redistributions in binary form must reproduce the above copyright
+    notice, this list of conditions and the following disclaimer in
+    the documentation and/or other materials provided with the
+    distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+"AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package coverage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/kb1vc/radiopath/location"
+	"github.com/kb1vc/radiopath/nedmap"
+	"github.com/kb1vc/radiopath/terrain"
+)
+
+// What to compute a viewshed for.
+type ViewshedParams struct {
+	TxHeight   float64 // antenna height above ground at the site in meters
+	RxHeight   float64 // receiving antenna height above ground in meters
+	Radius     float64 // how far out from the site to go in km
+	K          float64 // effective earth radius factor
+	CellArcSec int     // raster cell size in arcseconds
+	Workers    int     // number of radials computed at once; 0 for one per CPU
+}
+
+// Return viewshed parameters for the given antenna heights and radius,
+// with the standard atmosphere and the full resolution of the tiles.
+func DefaultViewshedParams(txHeight, rxHeight, radius float64) ViewshedParams {
+	return ViewshedParams{TxHeight: txHeight, RxHeight: rxHeight, Radius: radius,
+		K: terrain.DefaultK, CellArcSec: 1}
+}
+
+// The state of a viewshed cell while the radials are being computed.
+// A cell crossed by several radials is visible if any of them sees it.
+const (
+	cellUnseen int32 = iota
+	cellHidden
+	cellVisible
+)
+
+// raise the state at p to v, if it is lower
+func raise(p *int32, v int32) {
+	for {
+		old := atomic.LoadInt32(p)
+		if (old >= v) || atomic.CompareAndSwapInt32(p, old, v) {
+			return
+		}
+	}
+}
+
+// Return a raster covering the circle of radius km around center.
+func circleRaster(center location.LatLon, radius float64, cellArcSec int) (*Raster, error) {
+	n := center.OnPath(0.0, radius)
+	s := center.OnPath(180.0, radius)
+	e := center.OnPath(90.0, radius)
+	w := center.OnPath(270.0, radius)
+	// parallels curve away from the east-west geodesic, so the circle
+	// reaches a little further east and west than e and w
+	margin := 0.02 * (e.Lon - w.Lon)
+	return NewRaster(location.LatLon{Lat: s.Lat, Lon: w.Lon - margin},
+		location.LatLon{Lat: n.Lat, Lon: e.Lon + margin}, cellArcSec)
+}
+
+// the distance in km between samples along a radial: the smaller
+// side of a cell
+func sampleSpacing(r *Raster, lat float64) float64 {
+	const kmPerDegree = 111.2
+	return r.Cell * kmPerDegree * math.Min(1.0, math.Cos(lat*math.Pi/180.0))
+}
+
+// Compute the viewshed of a transmitter at site: every cell within
+// par.Radius km is 1 if a receiving antenna par.RxHeight meters above
+// the ground there has line of sight to the transmitting antenna, and 0
+// if not. Cells beyond the radius, or beyond the edge of the map data,
+// have no value (NaN).
+//
+// The viewshed is built from radials out from the site at evenly spaced
+// azimuths, as many as there are cells around the edge of the raster.
+// The cells are on a lat/lon grid, so this is only roughly one radial
+// per edge cell, and a cell that no radial sample falls in also has no
+// value. Radials are independent and are computed in parallel.
+func Viewshed(src nedmap.ElevationSource, site location.LatLon, par ViewshedParams) (*Raster, error) {
+	if !(par.Radius > 0.0) {
+		return nil, errors.New(fmt.Sprintf("Viewshed radius must be positive, got %f", par.Radius))
+	}
+	if !(par.K > 0.0) {
+		return nil, errors.New(fmt.Sprintf("Effective earth radius factor must be positive, got %f", par.K))
+	}
+
+	ground, err := src.ElevationAt(site)
+	if err != nil {
+		return nil, err
+	}
+	h0 := ground + par.TxHeight
+
+	ras, err := circleRaster(site, par.Radius, par.CellArcSec)
+	if err != nil {
+		return nil, err
+	}
+	state := make([]int32, len(ras.Data))
+	if row, col, ok := ras.Index(site); ok {
+		state[row*ras.Cols+col] = cellVisible
+	}
+
+	spacing := sampleSpacing(ras, site.Lat)
+	nrad := 2 * (ras.Rows + ras.Cols)
+	azstep := 360.0 / float64(nrad)
+
+	radial := func(az float64) {
+		pts, err := location.NewPath(site, site.OnPath(az, par.Radius), location.ShortPath).Every(spacing)
+		if err != nil {
+			return
+		}
+		// the steepest slope (m/km) to the terrain seen so far
+		maxSlope := math.Inf(-1)
+		for _, pt := range pts[1:] {
+			el, err := src.ElevationAt(pt.Pos)
+			if err != nil {
+				// off the edge of the map data
+				return
+			}
+			row, col, ok := ras.Index(pt.Pos)
+			if !ok {
+				continue
+			}
+			drop := pt.Dist * pt.Dist * 1000.0 / (2.0 * par.K * terrain.EarthRadius)
+			ground := (el - drop - h0) / pt.Dist
+			target := (el + par.RxHeight - drop - h0) / pt.Dist
+
+			if target >= maxSlope {
+				raise(&state[row*ras.Cols+col], cellVisible)
+			} else {
+				raise(&state[row*ras.Cols+col], cellHidden)
+			}
+			maxSlope = math.Max(maxSlope, ground)
+		}
+	}
+
+	workers := par.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	next := make(chan int, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				radial(azstep * float64(i))
+			}
+		}()
+	}
+	for i := 0; i < nrad; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	for i, st := range state {
+		switch st {
+		case cellVisible:
+			ras.Data[i] = 1.0
+		case cellHidden:
+			ras.Data[i] = 0.0
+		}
+	}
+	return ras, nil
+}
@@ -0,0 +1,227 @@
+/*
+Copyright (c) 2012, Matthew H. Reilly (kb1vc)
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are
+met:
+
+    Redistributions of source code must retain the above copyright
+    notice, this list of conditions and the following disclaimer.
+    Redistributions in binary form must reproduce the above copyright
+    notice, this list of conditions and the following disclaimer in
+    the documentation and/or other materials provided with the
+    distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+"AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+// Point-to-point path loss over a terrain profile with the
+// Irregular Terrain Model
+package itm
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/kb1vc/radiopath/nedmap"
+)
+
+// Antenna polarization
+type Polarization int
+
+const (
+	Horizontal Polarization = 0
+	Vertical   Polarization = 1
+)
+
+// Radio climate, numbered as in the ITM reference
+type Climate int
+
+const (
+	Equatorial                Climate = 1
+	ContinentalSubtropical    Climate = 2
+	MaritimeSubtropical       Climate = 3
+	Desert                    Climate = 4
+	ContinentalTemperate      Climate = 5
+	MaritimeTemperateOverLand Climate = 6
+	MaritimeTemperateOverSea  Climate = 7
+)
+
+// Mode of variability: how time, location and situation
+// variability are combined. Add 10 to eliminate location variability
+// and 20 to eliminate situation variability. The reference point to
+// point calculation uses MobileMode + 10.
+const (
+	SingleMessageMode = 0
+	IndividualMode    = 1
+	MobileMode        = 2
+	BroadcastMode     = 3
+)
+
+// Inputs to the model, other than the terrain profile.
+type Params struct {
+	FreqMHz      float64      // 20 MHz to 20 GHz
+	TxHeight     float64      // transmit antenna height above ground in meters
+	RxHeight     float64      // receive antenna height above ground in meters
+	Polarization Polarization // antenna polarization
+	Dielectric   float64      // relative permittivity of the ground
+	Conductivity float64      // ground conductivity in S/m
+	Refractivity float64      // surface refractivity in N-units
+	Climate      Climate      // radio climate
+	Variability  int          // mode of variability
+	Time         float64      // fraction of time, 0 < Time < 1
+	Location     float64      // fraction of locations, 0 < Location < 1
+	Situation    float64      // fraction of situations (confidence), 0 < Situation < 1
+}
+
+// Return the usual parameters for a path over average ground in
+// a continental temperate climate (most of the US), for the median
+// of time, locations and situations.
+func DefaultParams(freqMHz, txHeight, rxHeight float64) Params {
+	return Params{FreqMHz: freqMHz, TxHeight: txHeight, RxHeight: rxHeight,
+		Polarization: Vertical,
+		Dielectric:   15.0, Conductivity: 0.005, Refractivity: 301.0,
+		Climate: ContinentalTemperate, Variability: MobileMode + 10,
+		Time: 0.5, Location: 0.5, Situation: 0.5}
+}
+
+// Dominant propagation mechanism over a path.
+type Mode int
+
+const (
+	LineOfSight Mode = iota
+	SingleHorizonDiffraction
+	SingleHorizonTroposcatter
+	DoubleHorizonDiffraction
+	DoubleHorizonTroposcatter
+)
+
+func (m Mode) String() string {
+	switch m {
+	case LineOfSight:
+		return "Line-Of-Sight Mode"
+	case SingleHorizonDiffraction:
+		return "Single Horizon, Diffraction Dominant"
+	case SingleHorizonTroposcatter:
+		return "Single Horizon, Troposcatter Dominant"
+	case DoubleHorizonDiffraction:
+		return "Double Horizon, Diffraction Dominant"
+	case DoubleHorizonTroposcatter:
+		return "Double Horizon, Troposcatter Dominant"
+	}
+	return fmt.Sprintf("Mode(%d)", int(m))
+}
+
+// Output of the model.
+type Result struct {
+	Loss          float64 // basic transmission loss in dB for the requested time, location and situation
+	MedianLoss    float64 // basic transmission loss in dB for the median time, location and situation
+	FreeSpaceLoss float64 // free space loss in dB over the path distance
+	Reference     float64 // median attenuation relative to free space in dB
+	Mode          Mode    // dominant propagation mechanism
+	// 0: no warning
+	// 1: some parameters are near the limits of validity
+	// 2: a default was substituted for an impossible parameter
+	// 3: some parameters are out of range; results are dubious
+	// 4: some parameters are far out of range; results are meaningless
+	Warning int
+}
+
+// Predict the path loss over a terrain profile with the ITM in
+// point-to-point mode. The profile must be evenly spaced, as from
+// nedmap.GetProfile, with the transmitter at the first point.
+func PointToPoint(prof []nedmap.ProfilePoint, par Params) (Result, error) {
+	if len(prof) < 3 {
+		return Result{}, errors.New(fmt.Sprintf("ITM needs a profile of at least three points, got %d", len(prof)))
+	}
+	if !(par.FreqMHz > 0.0) || math.IsInf(par.FreqMHz, 0) {
+		return Result{}, errors.New(fmt.Sprintf("Frequency must be positive, got %f MHz", par.FreqMHz))
+	}
+	for _, h := range []float64{par.TxHeight, par.RxHeight} {
+		if !(h >= 0.0) || math.IsInf(h, 0) {
+			return Result{}, errors.New(fmt.Sprintf("Antenna heights must be zero or more meters, got %f", h))
+		}
+	}
+	for i, p := range prof {
+		if math.IsNaN(p.Elevation) || math.IsInf(p.Elevation, 0) {
+			return Result{}, errors.New(fmt.Sprintf("Profile point %d has no elevation (%f)", i, p.Elevation))
+		}
+	}
+	for _, f := range []float64{par.Time, par.Location, par.Situation} {
+		if !((f > 0.0) && (f < 1.0)) {
+			return Result{}, errors.New(fmt.Sprintf("Time, location and situation must be fractions between 0 and 1, got %f", f))
+		}
+	}
+
+	// the reference profile format: number of intervals, interval
+	// in meters, then the elevations
+	np := len(prof) - 1
+	dist := prof[np].Dist * 1000.0
+	if dist <= 0.0 {
+		return Result{}, errors.New("ITM needs a path of non-zero length")
+	}
+	pfl := make([]float64, np+3)
+	pfl[0] = float64(np)
+	pfl[1] = dist / float64(np)
+	for i, p := range prof {
+		pfl[i+2] = p.Elevation
+	}
+
+	var m model
+	m.prop.hg[0] = par.TxHeight
+	m.prop.hg[1] = par.RxHeight
+	m.propv.klim = int(par.Climate)
+	m.prop.kwx = 0
+	m.propv.lvar = 5
+	m.prop.mdp = -1
+
+	// the mean elevation of the middle of the path
+	var zsys float64
+	ja := int(3.0 + 0.1*pfl[0])
+	jb := np - ja + 6
+	for i := ja - 1; i < jb; i++ {
+		zsys += pfl[i]
+	}
+	zsys /= float64(jb - ja + 1)
+
+	m.propv.mdvar = par.Variability
+	m.qlrps(par.FreqMHz, zsys, par.Refractivity, int(par.Polarization), par.Dielectric, par.Conductivity)
+	m.qlrpfl(pfl, m.propv.klim, m.propv.mdvar)
+
+	var ret Result
+	ret.FreeSpaceLoss = 32.45 + 20.0*math.Log10(par.FreqMHz) + 20.0*math.Log10(m.prop.dist/1000.0)
+	ret.Reference = m.prop.aref
+
+	q := int(m.prop.dist - m.propa.dla)
+	troposcatter := (m.prop.dist > m.propa.dlsa) && (m.prop.dist > m.propa.dx)
+	switch {
+	case q < 0:
+		ret.Mode = LineOfSight
+	case (q == 0) && troposcatter:
+		ret.Mode = SingleHorizonTroposcatter
+	case q == 0:
+		ret.Mode = SingleHorizonDiffraction
+	case troposcatter:
+		ret.Mode = DoubleHorizonTroposcatter
+	default:
+		ret.Mode = DoubleHorizonDiffraction
+	}
+
+	ret.Loss = m.avar(qerfi(par.Time), qerfi(par.Location), qerfi(par.Situation)) + ret.FreeSpaceLoss
+	ret.MedianLoss = m.avar(0.0, 0.0, 0.0) + ret.FreeSpaceLoss
+	ret.Warning = m.prop.kwx
+
+	return ret, nil
+}
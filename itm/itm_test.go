@@ -0,0 +1,58 @@
+// Tests for the checks on the input to the ITM.
+package itm
+
+import (
+	"math"
+	"testing"
+
+	"github.com/kb1vc/radiopath/location"
+	"github.com/kb1vc/radiopath/nedmap"
+)
+
+// a gently rolling 20 km profile sampled every 100 m
+func testProfile() []nedmap.ProfilePoint {
+	ret := make([]nedmap.ProfilePoint, 201)
+	for i := range ret {
+		d := 0.1 * float64(i)
+		ret[i] = nedmap.ProfilePoint{Dist: d, Pos: location.LatLon{Lat: 42.5, Lon: -71.5 + d/82.0},
+			Elevation: 100.0 + 30.0*math.Sin(d/3.0)}
+	}
+	return ret
+}
+
+func TestPointToPointRejectsBadInput(t *testing.T) {
+	nan, inf := math.NaN(), math.Inf(1)
+	if _, err := PointToPoint(testProfile(), DefaultParams(144.0, 10.0, 10.0)); err != nil {
+		t.Fatalf("good input: %v", err)
+	}
+
+	params := map[string]Params{
+		"NaN tx height":      DefaultParams(144.0, nan, 10.0),
+		"infinite tx height": DefaultParams(144.0, inf, 10.0),
+		"negative tx height": DefaultParams(144.0, -1.0, 10.0),
+		"NaN rx height":      DefaultParams(144.0, 10.0, nan),
+		"infinite rx height": DefaultParams(144.0, 10.0, -inf),
+		"negative rx height": DefaultParams(144.0, 10.0, -5.0),
+		"NaN frequency":      DefaultParams(nan, 10.0, 10.0),
+		"infinite frequency": DefaultParams(inf, 10.0, 10.0),
+		"zero frequency":     DefaultParams(0.0, 10.0, 10.0),
+	}
+	nanTime := DefaultParams(144.0, 10.0, 10.0)
+	nanTime.Time = nan
+	params["NaN time"] = nanTime
+	for what, par := range params {
+		if _, err := PointToPoint(testProfile(), par); err == nil {
+			t.Errorf("%s: no error", what)
+		}
+	}
+
+	for what, el := range map[string]float64{"NaN": nan, "infinite": inf, "-infinite": -inf} {
+		for _, i := range []int{0, 100, 200} {
+			prof := testProfile()
+			prof[i].Elevation = el
+			if _, err := PointToPoint(prof, DefaultParams(144.0, 10.0, 10.0)); err == nil {
+				t.Errorf("%s elevation at point %d: no error", what, i)
+			}
+		}
+	}
+}
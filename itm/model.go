@@ -0,0 +1,837 @@
+/*
+Copyright (c) 2012, Matthew H. Reilly (kb1vc)
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are
+met:
+
+    Redistributions of source code must retain the above copyright
+    notice, this list of conditions and the following disclaimer.
+    Redistributions in binary form must reproduce the above copyright
+    notice, this list of conditions and the following disclaimer in
+    the documentation and/or other materials provided with the
+    distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+"AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+// The Irregular Terrain Model (Longley-Rice) in point-to-point mode.
+//
+// This is a translation of the NTIA/ITS reference implementation of
+// ITM version 1.2.2 (G. A. Hufford, public domain). The structure and
+// the names of the working variables follow the reference code so that
+// the two can be compared line by line.
+package itm
+
+import (
+	"math"
+	"math/cmplx"
+	"sort"
+)
+
+const third = 1.0 / 3.0
+
+// path parameters (prop_type in the reference code)
+type prop struct {
+	aref float64    // reference attenuation
+	dist float64    // path distance in meters
+	hg   [2]float64 // antenna heights above ground
+	wn   float64    // wave number (frequency in MHz / 47.7)
+	dh   float64    // terrain irregularity parameter
+	ens  float64    // surface refractivity
+	gme  float64    // effective earth curvature
+	zgnd complex128 // surface transfer impedance
+	he   [2]float64 // effective antenna heights
+	dl   [2]float64 // horizon distances
+	the  [2]float64 // horizon elevation angles
+	kwx  int        // error indicator
+	mdp  int        // mode of the propagation calculation
+}
+
+// variability parameters (propv_type)
+type propv struct {
+	sgc   float64
+	lvar  int
+	mdvar int
+	klim  int
+}
+
+// secondary parameters computed by lrprop (propa_type)
+type propa struct {
+	dlsa, dx, ael, ak1, ak2, aed, emd, aes, ems float64
+	dls                                         [2]float64
+	dla, tha                                    float64
+}
+
+// One point-to-point calculation. The reference code keeps these
+// values in function-scope statics; here they live with the calculation
+// so that separate calculations may run at the same time.
+type model struct {
+	prop  prop
+	propa propa
+	propv propv
+
+	// adiff
+	wd1, xd1, afo, qk, aht, xht float64
+	// ascat
+	ad, rr, etq, h0s float64
+	// alos
+	wls float64
+	// lrprop
+	wlos, wscat bool
+	dmin, xae   float64
+	// avar
+	kdv                                     int
+	dexa, de, vmd, vs0, sgl, sgtm, sgtp     float64
+	sgtd, tgtd, gm, gp, cv1, cv2, yv1, yv2  float64
+	yv3, csm1, csm2, ysm1, ysm2, ysm3, csp1 float64
+	csp2, ysp1, ysp2, ysp3, csd1, zd, cfm1  float64
+	cfm2, cfm3, cfp1, cfp2, cfp3            float64
+	ws, w1                                  bool
+}
+
+// FORTRAN's DIM: positive difference
+func dim(x, y float64) float64 {
+	if x > y {
+		return x - y
+	}
+	return 0.0
+}
+
+func aknfe(v2 float64) float64 {
+	if v2 < 5.76 {
+		return 6.02 + 9.11*math.Sqrt(v2) - 1.27*v2
+	}
+	return 12.953 + 10.0*math.Log10(v2)
+}
+
+func fht(x, pk float64) float64 {
+	var fhtv float64
+	if x < 200.0 {
+		w := -math.Log(pk)
+		if (pk < 1e-5) || (x*w*w*w > 5495.0) {
+			fhtv = -117.0
+			if x > 1.0 {
+				fhtv = 17.372*math.Log(x) + fhtv
+			}
+		} else {
+			fhtv = 2.5e-5*x*x/pk - 8.686*w - 15.0
+		}
+	} else {
+		fhtv = 0.05751*x - 10.0*math.Log10(x)
+		if x < 2000.0 {
+			w := 0.0134 * x * math.Exp(-0.005*x)
+			fhtv = (1.0-w)*fhtv + w*(17.372*math.Log(x)-117.0)
+		}
+	}
+	return fhtv
+}
+
+func h0f(r, et float64) float64 {
+	a := [5]float64{25.0, 80.0, 177.0, 395.0, 705.0}
+	b := [5]float64{24.0, 45.0, 68.0, 80.0, 105.0}
+	var q float64
+
+	it := int(et)
+	if it <= 0 {
+		it = 1
+		q = 0.0
+	} else if it >= 5 {
+		it = 5
+		q = 0.0
+	} else {
+		q = et - float64(it)
+	}
+
+	x := (1.0 / r) * (1.0 / r)
+	h0fv := 4.343 * math.Log((a[it-1]*x+b[it-1])*x+1.0)
+	if q != 0.0 {
+		h0fv = (1.0-q)*h0fv + q*4.343*math.Log((a[it]*x+b[it])*x+1.0)
+	}
+	return h0fv
+}
+
+func ahd(td float64) float64 {
+	a := [3]float64{133.4, 104.6, 71.8}
+	b := [3]float64{0.332e-3, 0.212e-3, 0.157e-3}
+	c := [3]float64{-4.343, -1.086, 2.171}
+
+	i := 2
+	if td <= 10e3 {
+		i = 0
+	} else if td <= 70e3 {
+		i = 1
+	}
+	return a[i] + b[i]*td + c[i]*math.Log(td)
+}
+
+// diffraction attenuation at distance d
+func (m *model) adiff(d float64) float64 {
+	p, pa := &m.prop, &m.propa
+
+	if d == 0.0 {
+		q := p.hg[0] * p.hg[1]
+		m.qk = p.he[0]*p.he[1] - q
+		if p.mdp < 0 {
+			q += 10.0
+		}
+		m.wd1 = math.Sqrt(1.0 + m.qk/q)
+		m.xd1 = pa.dla + pa.tha/p.gme
+		q = (1.0 - 0.8*math.Exp(-pa.dlsa/50e3)) * p.dh
+		q *= 0.78 * math.Exp(-math.Pow(q/16.0, 0.25))
+		m.afo = math.Min(15.0, 2.171*math.Log(1.0+4.77e-4*p.hg[0]*p.hg[1]*p.wn*q))
+		m.qk = 1.0 / cmplx.Abs(p.zgnd)
+		m.aht = 20.0
+		m.xht = 0.0
+		for j := 0; j < 2; j++ {
+			a := 0.5 * p.dl[j] * p.dl[j] / p.he[j]
+			wa := math.Pow(a*p.wn, third)
+			pk := m.qk / wa
+			q = (1.607 - pk) * 151.0 * wa * p.dl[j] / a
+			m.xht += q
+			m.aht += fht(q, pk)
+		}
+		return 0.0
+	}
+
+	th := pa.tha + d*p.gme
+	ds := d - pa.dla
+	q := 0.0795775 * p.wn * ds * th * th
+	adiffv := aknfe(q*p.dl[0]/(ds+p.dl[0])) + aknfe(q*p.dl[1]/(ds+p.dl[1]))
+	a := ds / th
+	wa := math.Pow(a*p.wn, third)
+	pk := m.qk / wa
+	q = (1.607-pk)*151.0*wa*th + m.xht
+	ar := 0.05751*q - 4.343*math.Log(q) - m.aht
+	q = (m.wd1 + m.xd1/d) * math.Min((1.0-0.8*math.Exp(-d/50e3))*p.dh*p.wn, 6283.2)
+	wd := 25.1 / (25.1 + math.Sqrt(q))
+	return ar*wd + (1.0-wd)*adiffv + m.afo
+}
+
+// troposcatter attenuation at distance d
+func (m *model) ascat(d float64) float64 {
+	p, pa := &m.prop, &m.propa
+
+	if d == 0.0 {
+		m.ad = p.dl[0] - p.dl[1]
+		m.rr = p.he[1] / p.he[0]
+		if m.ad < 0.0 {
+			m.ad = -m.ad
+			m.rr = 1.0 / m.rr
+		}
+		m.etq = (5.67e-6*p.ens-2.32e-3)*p.ens + 0.031
+		m.h0s = -15.0
+		return 0.0
+	}
+
+	var h0 float64
+	if m.h0s > 15.0 {
+		h0 = m.h0s
+	} else {
+		th := p.the[0] + p.the[1] + d*p.gme
+		r2 := 2.0 * p.wn * th
+		r1 := r2 * p.he[0]
+		r2 *= p.he[1]
+		if (r1 < 0.2) && (r2 < 0.2) {
+			return 1001.0
+		}
+		ss := (d - m.ad) / (d + m.ad)
+		q := m.rr / ss
+		ss = math.Max(0.1, ss)
+		q = math.Min(math.Max(0.1, q), 10.0)
+		z0 := (d - m.ad) * (d + m.ad) * th * 0.25 / d
+		temp := math.Pow(math.Min(1.7, z0/8.0e3), 6.0)
+		et := (m.etq*math.Exp(-temp) + 1.0) * z0 / 1.7556e3
+		ett := math.Max(et, 1.0)
+		h0 = (h0f(r1, ett) + h0f(r2, ett)) * 0.5
+		h0 += math.Min(h0, (1.38-math.Log(ett))*math.Log(ss)*math.Log(q)*0.49)
+		h0 = dim(h0, 0.0)
+		if et < 1.0 {
+			temp = (1.0 + 1.4142/r1) * (1.0 + 1.4142/r2)
+			h0 = et*h0 + (1.0-et)*4.343*math.Log(temp*temp*(r1+r2)/(r1+r2+2.8284))
+		}
+		if (h0 > 15.0) && (m.h0s >= 0.0) {
+			h0 = m.h0s
+		}
+	}
+	m.h0s = h0
+	th := pa.tha + d*p.gme
+	return ahd(th*d) + 4.343*math.Log(47.7*p.wn*math.Pow(th, 4.0)) -
+		0.1*(p.ens-301.0)*math.Exp(-th*d/40e3) + h0
+}
+
+// inverse of the standard normal complementary probability function
+func qerfi(q float64) float64 {
+	const c0 = 2.515516698
+	const c1 = 0.802853
+	const c2 = 0.010328
+	const d1 = 1.432788
+	const d2 = 0.189269
+	const d3 = 0.001308
+
+	x := 0.5 - q
+	t := math.Max(0.5-math.Abs(x), 0.000001)
+	t = math.Sqrt(-2.0 * math.Log(t))
+	v := t - ((c2*t+c1)*t+c0)/(((d3*t+d2)*t+d1)*t+1.0)
+	if x < 0.0 {
+		v = -v
+	}
+	return v
+}
+
+// prepare the model for a frequency, system elevation, refractivity,
+// polarization and ground constants.
+func (m *model) qlrps(fmhz, zsys, en0 float64, ipol int, eps, sgm float64) {
+	const gma = 157e-9
+	p := &m.prop
+
+	p.wn = fmhz / 47.7
+	p.ens = en0
+	if zsys != 0.0 {
+		p.ens *= math.Exp(-zsys / 9460.0)
+	}
+	p.gme = gma * (1.0 - 0.04665*math.Exp(p.ens/179.3))
+	zq := complex(eps, 376.62*sgm/p.wn)
+	p.zgnd = cmplx.Sqrt(zq - 1.0)
+	if ipol != 0 {
+		p.zgnd = p.zgnd / zq
+	}
+}
+
+func abqAlos(r complex128) float64 {
+	return real(r)*real(r) + imag(r)*imag(r)
+}
+
+// line of sight attenuation at distance d
+func (m *model) alos(d float64) float64 {
+	p, pa := &m.prop, &m.propa
+
+	if d == 0.0 {
+		m.wls = 0.021 / (0.021 + p.wn*p.dh/math.Max(10e3, pa.dlsa))
+		return 0.0
+	}
+
+	q := (1.0 - 0.8*math.Exp(-d/50e3)) * p.dh
+	s := 0.78 * q * math.Exp(-math.Pow(q/16.0, 0.25))
+	q = p.he[0] + p.he[1]
+	sps := q / math.Sqrt(d*d+q*q)
+	r := (complex(sps, 0) - p.zgnd) / (complex(sps, 0) + p.zgnd) *
+		complex(math.Exp(-math.Min(10.0, p.wn*s*sps)), 0)
+	q = abqAlos(r)
+	if (q < 0.25) || (q < sps) {
+		r = r * complex(math.Sqrt(sps/q), 0)
+	}
+	alosv := pa.emd*d + pa.aed
+	q = p.wn * p.he[0] * p.he[1] * 2.0 / d
+	if q > 1.57 {
+		q = 3.14 - 2.4649/q
+	}
+	return (-4.343*math.Log(abqAlos(complex(math.Cos(q), -math.Sin(q))+r))-alosv)*m.wls + alosv
+}
+
+// the reference attenuation at distance d
+func (m *model) lrprop(d float64) {
+	p, pa := &m.prop, &m.propa
+	var a0, a1, a2, a3, a4, a5, a6 float64
+	var d0, d1, d2, d3, d4, d5, d6 float64
+	var q float64
+
+	if p.mdp != 0 {
+		for j := 0; j < 2; j++ {
+			pa.dls[j] = math.Sqrt(2.0 * p.he[j] / p.gme)
+		}
+		pa.dlsa = pa.dls[0] + pa.dls[1]
+		pa.dla = p.dl[0] + p.dl[1]
+		pa.tha = math.Max(p.the[0]+p.the[1], -pa.dla*p.gme)
+		m.wlos = false
+		m.wscat = false
+
+		if (p.wn < 0.838) || (p.wn > 210.0) {
+			p.kwx = maxInt(p.kwx, 1)
+		}
+		for j := 0; j < 2; j++ {
+			if (p.hg[j] < 1.0) || (p.hg[j] > 1000.0) {
+				p.kwx = maxInt(p.kwx, 1)
+			}
+		}
+		for j := 0; j < 2; j++ {
+			if (math.Abs(p.the[j]) > 200e-3) || (p.dl[j] < 0.1*pa.dls[j]) || (p.dl[j] > 3.0*pa.dls[j]) {
+				p.kwx = maxInt(p.kwx, 3)
+			}
+		}
+		if (p.ens < 250.0) || (p.ens > 400.0) || (p.gme < 75e-9) || (p.gme > 250e-9) ||
+			(real(p.zgnd) <= math.Abs(imag(p.zgnd))) || (p.wn < 0.419) || (p.wn > 420.0) {
+			p.kwx = 4
+		}
+		for j := 0; j < 2; j++ {
+			if (p.hg[j] < 0.5) || (p.hg[j] > 3000.0) {
+				p.kwx = 4
+			}
+		}
+		m.dmin = math.Abs(p.he[0]-p.he[1]) / 200e-3
+		m.adiff(0.0)
+		m.xae = math.Pow(p.wn*p.gme*p.gme, -third)
+		d3 = math.Max(pa.dlsa, 1.3787*m.xae+pa.dla)
+		d4 = d3 + 2.7574*m.xae
+		a3 = m.adiff(d3)
+		a4 = m.adiff(d4)
+		pa.emd = (a4 - a3) / (d4 - d3)
+		pa.aed = a3 - pa.emd*d3
+	}
+
+	if p.mdp >= 0 {
+		p.mdp = 0
+		p.dist = d
+	}
+
+	if p.dist > 0.0 {
+		if p.dist > 1000e3 {
+			p.kwx = maxInt(p.kwx, 1)
+		}
+		if p.dist < m.dmin {
+			p.kwx = maxInt(p.kwx, 3)
+		}
+		if (p.dist < 1e3) || (p.dist > 2000e3) {
+			p.kwx = 4
+		}
+	}
+
+	if p.dist < pa.dlsa {
+		if !m.wlos {
+			m.alos(0.0)
+			d2 = pa.dlsa
+			a2 = pa.aed + d2*pa.emd
+			d0 = 1.908 * p.wn * p.he[0] * p.he[1]
+			if pa.aed >= 0.0 {
+				d0 = math.Min(d0, 0.5*pa.dla)
+				d1 = d0 + 0.25*(pa.dla-d0)
+			} else {
+				d1 = math.Max(-pa.aed/pa.emd, 0.25*pa.dla)
+			}
+			a1 = m.alos(d1)
+			if d0 < d1 {
+				a0 = m.alos(d0)
+				q = math.Log(d2 / d0)
+				pa.ak2 = math.Max(0.0, ((d2-d0)*(a1-a0)-(d1-d0)*(a2-a0))/((d2-d0)*math.Log(d1/d0)-(d1-d0)*q))
+				wq := (pa.aed >= 0.0) || (pa.ak2 > 0.0)
+				if wq {
+					pa.ak1 = (a2 - a0 - pa.ak2*q) / (d2 - d0)
+					if pa.ak1 < 0.0 {
+						pa.ak1 = 0.0
+						pa.ak2 = dim(a2, a0) / q
+						if pa.ak2 == 0.0 {
+							pa.ak1 = pa.emd
+						}
+					}
+				} else {
+					pa.ak2 = 0.0
+					pa.ak1 = (a2 - a1) / (d2 - d1)
+					if pa.ak1 <= 0.0 {
+						pa.ak1 = pa.emd
+					}
+				}
+			} else {
+				pa.ak1 = (a2 - a1) / (d2 - d1)
+				pa.ak2 = 0.0
+				if pa.ak1 <= 0.0 {
+					pa.ak1 = pa.emd
+				}
+			}
+			pa.ael = a2 - pa.ak1*d2 - pa.ak2*math.Log(d2)
+			m.wlos = true
+		}
+		if p.dist > 0.0 {
+			p.aref = pa.ael + pa.ak1*p.dist + pa.ak2*math.Log(p.dist)
+		}
+	}
+
+	if (p.dist <= 0.0) || (p.dist >= pa.dlsa) {
+		if !m.wscat {
+			m.ascat(0.0)
+			d5 = pa.dla + 200e3
+			d6 = d5 + 200e3
+			a6 = m.ascat(d6)
+			a5 = m.ascat(d5)
+			if a5 < 1000.0 {
+				pa.ems = (a6 - a5) / 200e3
+				pa.dx = math.Max(pa.dlsa, math.Max(pa.dla+0.3*m.xae*math.Log(47.7*p.wn),
+					(a5-pa.aed-pa.ems*d5)/(pa.emd-pa.ems)))
+				pa.aes = (pa.emd-pa.ems)*pa.dx + pa.aed
+			} else {
+				pa.ems = pa.emd
+				pa.aes = pa.aed
+				pa.dx = 10.e6
+			}
+			m.wscat = true
+		}
+		if p.dist > pa.dx {
+			p.aref = pa.aes + pa.ems*p.dist
+		} else {
+			p.aref = pa.aed + pa.emd*p.dist
+		}
+	}
+	p.aref = math.Max(p.aref, 0.0)
+}
+
+func curve(c1, c2, x1, x2, x3, de float64) float64 {
+	temp1 := (de - x2) / x3
+	temp2 := de / x1
+	temp1 *= temp1
+	temp2 *= temp2
+	return (c1 + c2/(1.0+temp1)) * temp2 / (1.0 + temp2)
+}
+
+// climate dependent constants for avar, indexed by climate - 1
+var (
+	bv1  = [7]float64{-9.67, -0.62, 1.26, -9.21, -0.62, -0.39, 3.15}
+	bv2  = [7]float64{12.7, 9.19, 15.5, 9.05, 9.19, 2.86, 857.9}
+	xv1  = [7]float64{144.9e3, 228.9e3, 262.6e3, 84.1e3, 228.9e3, 141.7e3, 2222.e3}
+	xv2  = [7]float64{190.3e3, 205.2e3, 185.2e3, 101.1e3, 205.2e3, 315.9e3, 164.8e3}
+	xv3  = [7]float64{133.8e3, 143.6e3, 99.8e3, 98.6e3, 143.6e3, 167.4e3, 116.3e3}
+	bsm1 = [7]float64{2.13, 2.66, 6.11, 1.98, 2.68, 6.86, 8.51}
+	bsm2 = [7]float64{159.5, 7.67, 6.65, 13.11, 7.16, 10.38, 169.8}
+	xsm1 = [7]float64{762.2e3, 100.4e3, 138.2e3, 139.1e3, 93.7e3, 187.8e3, 609.8e3}
+	xsm2 = [7]float64{123.6e3, 172.5e3, 242.2e3, 132.7e3, 186.8e3, 169.6e3, 119.9e3}
+	xsm3 = [7]float64{94.5e3, 136.4e3, 178.6e3, 193.5e3, 133.5e3, 108.9e3, 106.6e3}
+	bsp1 = [7]float64{2.11, 6.87, 10.08, 3.68, 4.75, 8.58, 8.43}
+	bsp2 = [7]float64{102.3, 15.53, 9.60, 159.3, 8.12, 13.97, 8.19}
+	xsp1 = [7]float64{636.9e3, 138.7e3, 165.3e3, 464.4e3, 93.2e3, 216.0e3, 136.2e3}
+	xsp2 = [7]float64{134.8e3, 143.7e3, 225.7e3, 93.1e3, 135.9e3, 152.0e3, 188.5e3}
+	xsp3 = [7]float64{95.6e3, 98.6e3, 129.7e3, 94.2e3, 113.4e3, 122.7e3, 122.9e3}
+	bsd1 = [7]float64{1.224, 0.801, 1.380, 1.000, 1.224, 1.518, 1.518}
+	bzd1 = [7]float64{1.282, 2.161, 1.282, 20., 1.282, 1.282, 1.282}
+	bfm1 = [7]float64{1.0, 1.0, 1.0, 1.0, 0.92, 1.0, 1.0}
+	bfm2 = [7]float64{0.0, 0.0, 0.0, 0.0, 0.25, 0.0, 0.0}
+	bfm3 = [7]float64{0.0, 0.0, 0.0, 0.0, 1.77, 0.0, 0.0}
+	bfp1 = [7]float64{1.0, 0.93, 1.0, 0.93, 0.93, 1.0, 1.0}
+	bfp2 = [7]float64{0.0, 0.31, 0.0, 0.19, 0.31, 0.0, 0.0}
+	bfp3 = [7]float64{0.0, 2.00, 0.0, 1.79, 2.00, 0.0, 0.0}
+)
+
+// attenuation for the standard normal deviates zzt, zzl and zzc
+// of time, location and situation variability.
+func (m *model) avar(zzt, zzl, zzc float64) float64 {
+	p, pv := &m.prop, &m.propv
+	const rt = 7.8
+	const rl = 24.0
+	var q float64
+
+	if pv.lvar > 0 {
+		// the reference code falls through from each case to the next
+		if pv.lvar >= 5 {
+			if (pv.klim <= 0) || (pv.klim > 7) {
+				pv.klim = 5
+				p.kwx = maxInt(p.kwx, 2)
+			}
+			k := pv.klim - 1
+			m.cv1, m.cv2, m.yv1, m.yv2, m.yv3 = bv1[k], bv2[k], xv1[k], xv2[k], xv3[k]
+			m.csm1, m.csm2, m.ysm1, m.ysm2, m.ysm3 = bsm1[k], bsm2[k], xsm1[k], xsm2[k], xsm3[k]
+			m.csp1, m.csp2, m.ysp1, m.ysp2, m.ysp3 = bsp1[k], bsp2[k], xsp1[k], xsp2[k], xsp3[k]
+			m.csd1, m.zd = bsd1[k], bzd1[k]
+			m.cfm1, m.cfm2, m.cfm3 = bfm1[k], bfm2[k], bfm3[k]
+			m.cfp1, m.cfp2, m.cfp3 = bfp1[k], bfp2[k], bfp3[k]
+		}
+		if pv.lvar >= 4 {
+			m.kdv = pv.mdvar
+			m.ws = m.kdv >= 20
+			if m.ws {
+				m.kdv -= 20
+			}
+			m.w1 = m.kdv >= 10
+			if m.w1 {
+				m.kdv -= 10
+			}
+			if (m.kdv < 0) || (m.kdv > 3) {
+				m.kdv = 0
+				p.kwx = maxInt(p.kwx, 2)
+			}
+		}
+		if pv.lvar >= 3 {
+			q = math.Log(0.133 * p.wn)
+			m.gm = m.cfm1 + m.cfm2/((m.cfm3*q)*(m.cfm3*q)+1.0)
+			m.gp = m.cfp1 + m.cfp2/((m.cfp3*q)*(m.cfp3*q)+1.0)
+		}
+		if pv.lvar >= 2 {
+			m.dexa = math.Sqrt(18e6*p.he[0]) + math.Sqrt(18e6*p.he[1]) + math.Pow(575.7e12/p.wn, third)
+		}
+		if p.dist < m.dexa {
+			m.de = 130e3 * p.dist / m.dexa
+		} else {
+			m.de = 130e3 + p.dist - m.dexa
+		}
+
+		m.vmd = curve(m.cv1, m.cv2, m.yv1, m.yv2, m.yv3, m.de)
+		m.sgtm = curve(m.csm1, m.csm2, m.ysm1, m.ysm2, m.ysm3, m.de) * m.gm
+		m.sgtp = curve(m.csp1, m.csp2, m.ysp1, m.ysp2, m.ysp3, m.de) * m.gp
+		m.sgtd = m.sgtp * m.csd1
+		m.tgtd = (m.sgtp - m.sgtd) * m.zd
+		if m.w1 {
+			m.sgl = 0.0
+		} else {
+			q = (1.0 - 0.8*math.Exp(-p.dist/50e3)) * p.dh * p.wn
+			m.sgl = 10.0 * q / (q + 13.0)
+		}
+		if m.ws {
+			m.vs0 = 0.0
+		} else {
+			temp1 := 5.0 + 3.0*math.Exp(-m.de/100e3)
+			m.vs0 = temp1 * temp1
+		}
+		pv.lvar = 0
+	}
+
+	zt, zl, zc := zzt, zzl, zzc
+	switch m.kdv {
+	case 0:
+		zt = zc
+		zl = zc
+	case 1:
+		zl = zc
+	case 2:
+		zl = zt
+	}
+	if (math.Abs(zt) > 3.1) || (math.Abs(zl) > 3.1) || (math.Abs(zc) > 3.1) {
+		p.kwx = maxInt(p.kwx, 1)
+	}
+
+	var sgt float64
+	if zt < 0.0 {
+		sgt = m.sgtm
+	} else if zt <= m.zd {
+		sgt = m.sgtp
+	} else {
+		sgt = m.sgtd + m.tgtd/zt
+	}
+	temp1 := sgt * zt
+	temp2 := m.sgl * zl
+	vs := m.vs0 + temp1*temp1/(rt+zc*zc) + temp2*temp2/(rl+zc*zc)
+
+	var yr float64
+	switch m.kdv {
+	case 0:
+		yr = 0.0
+		pv.sgc = math.Sqrt(sgt*sgt + m.sgl*m.sgl + vs)
+	case 1:
+		yr = sgt * zt
+		pv.sgc = math.Sqrt(m.sgl*m.sgl + vs)
+	case 2:
+		yr = math.Sqrt(sgt*sgt+m.sgl*m.sgl) * zt
+		pv.sgc = math.Sqrt(vs)
+	default:
+		yr = sgt*zt + m.sgl*zl
+		pv.sgc = math.Sqrt(vs)
+	}
+
+	avarv := p.aref - m.vmd - yr - pv.sgc*zc
+	if avarv < 0.0 {
+		avarv = avarv * (29.0 - avarv) / (29.0 - 10.0*avarv)
+	}
+	return avarv
+}
+
+// find the horizons along the profile
+func (m *model) hzns(pfl []float64) {
+	p := &m.prop
+	np := int(pfl[0])
+	xi := pfl[1]
+	za := pfl[2] + p.hg[0]
+	zb := pfl[np+2] + p.hg[1]
+	qc := 0.5 * p.gme
+	q := qc * p.dist
+
+	p.the[1] = (zb - za) / p.dist
+	p.the[0] = p.the[1] - q
+	p.the[1] = -p.the[1] - q
+	p.dl[0] = p.dist
+	p.dl[1] = p.dist
+
+	if np >= 2 {
+		sa := 0.0
+		sb := p.dist
+		wq := true
+		for i := 1; i < np; i++ {
+			sa += xi
+			sb -= xi
+			q = pfl[i+2] - (qc*sa+p.the[0])*sa - za
+			if q > 0.0 {
+				p.the[0] += q / sa
+				p.dl[0] = sa
+				wq = false
+			}
+			if !wq {
+				q = pfl[i+2] - (qc*sb+p.the[1])*sb - zb
+				if q > 0.0 {
+					p.the[1] += q / sb
+					p.dl[1] = sb
+				}
+			}
+		}
+	}
+}
+
+// least squares linear fit to the profile between x1 and x2,
+// returning the fitted heights at the two ends of the profile.
+func z1sq1(z []float64, x1, x2 float64) (float64, float64) {
+	xn := z[0]
+	xa := float64(int(dim(x1/z[1], 0.0)))
+	xb := xn - float64(int(dim(xn, x2/z[1])))
+	if xb <= xa {
+		xa = dim(xa, 1.0)
+		xb = xn - dim(xn, xb+1.0)
+	}
+	ja := int(xa)
+	jb := int(xb)
+	n := jb - ja
+	xa = xb - xa
+	x := -0.5 * xa
+	xb += x
+	a := 0.5 * (z[ja+2] + z[jb+2])
+	b := 0.5 * (z[ja+2] - z[jb+2]) * x
+	for i := 2; i <= n; i++ {
+		ja++
+		x += 1.0
+		a += z[ja+2]
+		b += z[ja+2] * x
+	}
+	a /= xa
+	b = b * 12.0 / ((xa*xa + 2.0) * xa)
+	return a - b*xb, a + b*(xn-xb)
+}
+
+// return the ia'th and ib'th largest of a[0:nn+1]. (The reference code
+// uses a partial quicksort for each; sorting once gives the same answers.)
+func qtiles(nn int, a []float64, ia, ib int) (float64, float64) {
+	s := make([]float64, nn+1)
+	copy(s, a[:nn+1])
+	sort.Float64s(s)
+	nth := func(ir int) float64 {
+		if ir < 0 {
+			ir = 0
+		} else if ir > nn {
+			ir = nn
+		}
+		return s[nn-ir]
+	}
+	return nth(ia), nth(ib)
+}
+
+// the interdecile range of terrain heights between x1 and x2,
+// after removing the linear trend
+func d1thx(pfl []float64, x1, x2 float64) float64 {
+	np := int(pfl[0])
+	xa := x1 / pfl[1]
+	xb := x2 / pfl[1]
+	if xb-xa < 2.0 {
+		return 0.0
+	}
+
+	ka := int(0.1 * (xb - xa + 8.0))
+	ka = minInt(maxInt(4, ka), 25)
+	n := 10*ka - 5
+	kb := n - ka + 1
+	sn := float64(n - 1)
+	s := make([]float64, n+2)
+	s[0] = sn
+	s[1] = 1.0
+	xb = (xb - xa) / sn
+	k := int(xa + 1.0)
+	xa -= float64(k)
+	for j := 0; j < n; j++ {
+		for (xa > 0.0) && (k < np) {
+			xa -= 1.0
+			k++
+		}
+		s[j+2] = pfl[k+2] + (pfl[k+2]-pfl[k+1])*xa
+		xa = xa + xb
+	}
+	xa, xb = z1sq1(s, 0.0, sn)
+	xb = (xb - xa) / sn
+	for j := 0; j < n; j++ {
+		s[j+2] -= xa
+		xa = xa + xb
+	}
+	qa, qb := qtiles(n-1, s[2:], ka-1, kb-1)
+	d1thxv := qa - qb
+	d1thxv /= 1.0 - 0.8*math.Exp(-(x2-x1)/50.0e3)
+	return d1thxv
+}
+
+// prepare the model for a terrain profile
+func (m *model) qlrpfl(pfl []float64, klimx, mdvarx int) {
+	p, pv := &m.prop, &m.propv
+	var xl [2]float64
+	var za, zb, q float64
+
+	p.dist = pfl[0] * pfl[1]
+	np := int(pfl[0])
+	m.hzns(pfl)
+	for j := 0; j < 2; j++ {
+		xl[j] = math.Min(15.0*p.hg[j], 0.1*p.dl[j])
+	}
+	xl[1] = p.dist - xl[1]
+	p.dh = d1thx(pfl, xl[0], xl[1])
+
+	if p.dl[0]+p.dl[1] > 1.5*p.dist {
+		za, zb = z1sq1(pfl, xl[0], xl[1])
+		p.he[0] = p.hg[0] + dim(pfl[2], za)
+		p.he[1] = p.hg[1] + dim(pfl[np+2], zb)
+		for j := 0; j < 2; j++ {
+			p.dl[j] = math.Sqrt(2.0*p.he[j]/p.gme) * math.Exp(-0.07*math.Sqrt(p.dh/math.Max(p.he[j], 5.0)))
+		}
+		q = p.dl[0] + p.dl[1]
+		if q <= p.dist {
+			temp := p.dist / q
+			q = temp * temp
+			for j := 0; j < 2; j++ {
+				p.he[j] *= q
+				p.dl[j] = math.Sqrt(2.0*p.he[j]/p.gme) * math.Exp(-0.07*math.Sqrt(p.dh/math.Max(p.he[j], 5.0)))
+			}
+		}
+		for j := 0; j < 2; j++ {
+			q = math.Sqrt(2.0 * p.he[j] / p.gme)
+			p.the[j] = (0.65*p.dh*(q/p.dl[j]-1.0) - 2.0*p.he[j]) / q
+		}
+	} else {
+		za, _ = z1sq1(pfl, xl[0], 0.9*p.dl[0])
+		_, zb = z1sq1(pfl, p.dist-0.9*p.dl[1], xl[1])
+		p.he[0] = p.hg[0] + dim(pfl[2], za)
+		p.he[1] = p.hg[1] + dim(pfl[np+2], zb)
+	}
+
+	p.mdp = -1
+	pv.lvar = maxInt(pv.lvar, 3)
+	if mdvarx >= 0 {
+		pv.mdvar = mdvarx
+		pv.lvar = maxInt(pv.lvar, 4)
+	}
+	if klimx > 0 {
+		pv.klim = klimx
+		pv.lvar = 5
+	}
+	m.lrprop(0.0)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
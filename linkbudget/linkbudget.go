@@ -0,0 +1,151 @@
+/*
+Copyright (c) 2012, Matthew H. Reilly (kb1vc)
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are
+met:
+
+    Redistributions of source code must retain the above copyright
+    notice, this list of conditions and the following disclaimer.
+    Redistributions in binary form must reproduce the above copyright
+    notice, this list of conditions and the following disclaimer in
+    the documentation and/or other materials provided with the
+    distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+"AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+// Path loss models and link budgets: will the signal get there?
+package linkbudget
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/cmplx"
+
+	"github.com/kb1vc/radiopath/location"
+	"github.com/kb1vc/radiopath/nedmap"
+)
+
+// speed of light in meters per second
+const speedOfLight = 299792458.0
+
+// thermal noise power density at 290K in dBm/Hz
+const thermalNoise = -174.0
+
+// Return the free space path loss in dB over distKm km at freqMHz.
+func FreeSpaceLoss(distKm, freqMHz float64) float64 {
+	return 32.45 + 20.0*math.Log10(freqMHz) + 20.0*math.Log10(distKm)
+}
+
+// Return the free space path loss in dB between two locations at freqMHz.
+func FreeSpaceLossBetween(from, to location.LatLon, freqMHz float64) float64 {
+	_, _, dist := from.Bearing(to)
+	return FreeSpaceLoss(dist, freqMHz)
+}
+
+// Return the path loss in dB over distKm km at freqMHz for a direct ray
+// plus a ray reflected from flat ground, with the antennas h1 and h2 meters
+// above the reflecting surface. The ground is taken to be a perfect
+// reflector at grazing incidence (reflection coefficient -1).
+func TwoRayLoss(distKm, freqMHz, h1, h2 float64) float64 {
+	d := distKm * 1000.0
+	direct := math.Sqrt(d*d + (h1-h2)*(h1-h2))
+	reflected := math.Sqrt(d*d + (h1+h2)*(h1+h2))
+	k := 2.0 * math.Pi * freqMHz * 1.0e6 / speedOfLight
+
+	// the sum of the two rays relative to the direct ray alone
+	sum := cmplx.Abs(1.0 - cmplx.Exp(complex(0.0, -k*(reflected-direct))))
+	return FreeSpaceLoss(distKm, freqMHz) - 20.0*math.Log10(sum)
+}
+
+// Return the two-ray path loss in dB over a terrain profile, with
+// antennas txHeight and rxHeight meters above the ground at the first
+// and last points. The ground is taken to be a flat reflector at the
+// height of the terrain at the specular reflection point, which is
+// also returned. The model only makes sense for line of sight paths.
+func TwoRayProfileLoss(prof []nedmap.ProfilePoint, txHeight, rxHeight, freqMHz float64) (float64, nedmap.ProfilePoint, error) {
+	if len(prof) < 2 {
+		return 0.0, nedmap.ProfilePoint{}, errors.New(fmt.Sprintf("A path profile needs at least two points, got %d", len(prof)))
+	}
+	last := len(prof) - 1
+	dist := prof[last].Dist
+	tx := prof[0].Elevation + txHeight
+	rx := prof[last].Elevation + rxHeight
+
+	// The reflection point divides the path in the ratio of the antenna
+	// heights above the reflecting surface. Start with the surface at the
+	// midpoint and settle on a point in a few steps.
+	idx := last / 2
+	for i := 0; i < 8; i++ {
+		g := prof[idx].Elevation
+		h1, h2 := math.Max(tx-g, 0.1), math.Max(rx-g, 0.1)
+		nidx := int(math.Floor(float64(last)*h1/(h1+h2) + 0.5))
+		if nidx == idx {
+			break
+		}
+		idx = nidx
+	}
+
+	g := prof[idx].Elevation
+	h1, h2 := math.Max(tx-g, 0.1), math.Max(rx-g, 0.1)
+	return TwoRayLoss(dist, freqMHz, h1, h2), prof[idx], nil
+}
+
+// The station parameters for a link.
+type Link struct {
+	TxPower     float64 // transmitter output in dBm
+	TxLineLoss  float64 // transmit feedline loss in dB
+	TxGain      float64 // transmit antenna gain in dBi
+	RxGain      float64 // receive antenna gain in dBi
+	RxLineLoss  float64 // receive feedline loss in dB
+	NoiseFigure float64 // receiver noise figure in dB
+	Bandwidth   float64 // receiver bandwidth in Hz
+	RequiredSNR float64 // the signal to noise ratio needed for the mode in dB
+}
+
+// The result of a link budget calculation.
+type Budget struct {
+	PathLoss   float64 // dB
+	EIRP       float64 // effective isotropic radiated power in dBm
+	RxPower    float64 // power at the receiver input in dBm
+	NoiseFloor float64 // receiver noise in its bandwidth in dBm
+	SNR        float64 // signal to noise ratio in dB
+	Margin     float64 // SNR above the required SNR in dB; negative means the link fails
+}
+
+// Work out the received power and the SNR margin for a path loss in dB.
+func (l Link) Budget(pathLoss float64) (Budget, error) {
+	if l.Bandwidth <= 0.0 {
+		return Budget{}, errors.New(fmt.Sprintf("Receiver bandwidth must be positive, got %f Hz", l.Bandwidth))
+	}
+
+	var ret Budget
+	ret.PathLoss = pathLoss
+	ret.EIRP = l.TxPower - l.TxLineLoss + l.TxGain
+	ret.RxPower = ret.EIRP - pathLoss + l.RxGain - l.RxLineLoss
+	ret.NoiseFloor = thermalNoise + 10.0*math.Log10(l.Bandwidth) + l.NoiseFigure
+	ret.SNR = ret.RxPower - ret.NoiseFloor
+	ret.Margin = ret.SNR - l.RequiredSNR
+	return ret, nil
+}
+
+// Return the field strength in dB(uV/m) at the far end of a path with
+// pathLoss dB of loss from a transmitter radiating eirp dBm toward it.
+// This is the field that delivers eirp - pathLoss dBm into an
+// isotropic antenna.
+func FieldStrength(eirp, pathLoss, freqMHz float64) float64 {
+	return eirp - pathLoss + 77.21 + 20.0*math.Log10(freqMHz)
+}
@@ -0,0 +1,65 @@
+/*
+Copyright (c) 2012, Matthew H. Reilly (kb1vc)
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are
+met:
+
+    Redistributions of source code must retain the above copyright
+    notice, this list of conditions and the following disclaimer.
+    Redistributions in binary form must reproduce the above copyright
+    notice, this list of conditions and the following disclaimer in
+    the documentation and/or other materials provided with the
+    distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+"AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+// Interpolation between values given at a list of azimuths.
+package location
+
+import (
+	"math"
+	"sort"
+)
+
+// Return the value at azimuth az (degrees) of a quantity known at the
+// given azimuths, interpolated linearly between the entries on either
+// side of az. The azimuths must be ascending and in [0, 360); the
+// interpolation wraps around north from the last entry to the first.
+func InterpolateAzimuth(azimuths, values []float64, az float64) float64 {
+	n := len(azimuths)
+	a := math.Mod(math.Mod(az, 360.0)+360.0, 360.0)
+
+	j := sort.SearchFloat64s(azimuths, a)
+	i := j - 1
+	lo, hi := 0.0, 0.0
+	if i < 0 {
+		i = n - 1
+		lo = azimuths[i] - 360.0
+	} else {
+		lo = azimuths[i]
+	}
+	if j >= n {
+		j = 0
+		hi = azimuths[0] + 360.0
+	} else {
+		hi = azimuths[j]
+	}
+	if hi <= lo {
+		return values[i]
+	}
+	f := (a - lo) / (hi - lo)
+	return values[i]*(1.0-f) + values[j]*f
+}
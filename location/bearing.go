@@ -33,11 +33,13 @@ import (
 	"math"
 )
 
-const clarkeAL = 6378206.4   /*Clarke 1866 ellipsoid*/
-const clarkeBL = 6356583.8
 const deg2rad  = (math.Pi / 180.0)
 const pi2 = (2.0 * math.Pi)
 
+// Bearing hands paths longer than this (in radians on the auxiliary
+// sphere) to the Karney inverse in geodesic.go.
+const antipodalLimit = 170.0 * deg2rad
+
 /*Determines bearing and distance based on*/
 /*algorithm compensating for earth's shape*/
 /* Modified and heavily plagiarized from code that is */
@@ -67,14 +69,21 @@ const pi2 = (2.0 * math.Pi)
 */
 
 // return (bearing, reverse bearing, distance) in degrees and km respectively
+// on the DefaultEllipsoid
 func (fr LatLon) Bearing(to LatLon) (float64, float64, float64) {
+	return DefaultEllipsoid.Bearing(fr, to)
+}
+
+// return (bearing, reverse bearing, distance) in degrees and km respectively
+// from fr to to on ellipsoid ell
+func (ell Ellipsoid) Bearing(fr, to LatLon) (float64, float64, float64) {
 	if (math.Abs(fr.Lat - to.Lat) < 1.0e-10) &&
 		(math.Abs(fr.Lon - to.Lon) < 1.0e-10) {
 		return 0.0, 0.0, 0.0
 	}
 
-	boa := clarkeBL / clarkeAL
-	f := 1.0 - boa
+	boa := ell.B() / ell.A
+	f := ell.F
 	p1r := fr.Lat * deg2rad
 	p2r := to.Lat * deg2rad
 	l1r := fr.Lon * deg2rad
@@ -100,6 +109,12 @@ func (fr LatLon) Bearing(to LatLon) (float64, float64, float64) {
 	l := sdtm * sdtm + sdlmr * sdlmr * (cdtm * cdtm - stm * stm)
 	cd := 1.0 - 2.0 * l
 	dl := math.Acos(cd)
+	if !(dl < antipodalLimit) {
+		// the Andoyer-Lambert terms lose accuracy (and finally
+		// divide by zero) as the points approach antipodal.
+		g := ell.Inverse(fr, to)
+		return g.Azimuth1, g.ReverseAzimuth(), g.Dist
+	}
 	sd := math.Sin(dl)
 	t := dl / sd
 
@@ -112,7 +127,7 @@ func (fr LatLon) Bearing(to LatLon) (float64, float64, float64) {
 	a := -d * e
 	ff64 := f * f / 64.0
 
-	dist := clarkeAL * sd * (t - f / 4.0 * (t * x - y) +
+	dist := ell.A * sd * (t - f / 4.0 * (t * x - y) +
 		ff64 * (x * (a + (t - (a + e) / 2.0) * x) +
 		y * (e * y - 2.0 * d) + d * x * y )) / 1000.0
 
@@ -154,11 +169,19 @@ func (fr LatLon) Bearing(to LatLon) (float64, float64, float64) {
 	return az, revaz, dist
 }
 
+// return the point dist km from fr along the path that starts
+// at bearing az degrees, on the DefaultEllipsoid
 func (fr LatLon) OnPath(az float64, dist float64) LatLon {
+	return DefaultEllipsoid.OnPath(fr, az, dist)
+}
+
+// return the point dist km from fr along the path that starts
+// at bearing az degrees, on ellipsoid ell
+func (ell Ellipsoid) OnPath(fr LatLon, az float64, dist float64) LatLon {
 
 	const eps = 5e-14;
-	const a = 6378206.4; /* (meters) */
-	const  f = 1.0/298.25722210088; 
+	a := ell.A /* (meters) */
+	f := ell.F
 
 	/* *** SOLUTION OF THE GEODETIC DIRECT PROBLEM AFTER T.VINCENTY */
 	/* *** MODIFIED RAINSFORD'S METHOD WITH HELMERT'S ELLIPTICAL TERMS */
@@ -0,0 +1,190 @@
+/*
+Copyright (c) 2012, Matthew H. Reilly (kb1vc)
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are
+met:
+
+    Redistributions of source code must retain the above copyright
+    notice, this list of conditions and the following disclaimer.
+    Redistributions in binary form must reproduce the above copyright
+    notice, this list of conditions and the following disclaimer in
+    the documentation and/or other materials provided with the
+    distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+"AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+// Earth-centered earth-fixed coordinates and local east-north-up
+// frames. Heights are meters above the ellipsoid, and so are all
+// the cartesian coordinates here.
+package location
+
+import (
+	"math"
+)
+
+// A point in earth-centered earth-fixed coordinates, in meters.
+// Z points to the north pole, X to 0 lat 0 lon.
+type ECEF struct {
+	X, Y, Z float64
+}
+
+// A point in a local east-north-up frame, in meters.
+type ENU struct {
+	E, N, U float64
+}
+
+// Return the ECEF coordinates of ll, h meters above the DefaultEllipsoid.
+func (ll LatLon) ECEF(h float64) ECEF {
+	return DefaultEllipsoid.ToECEF(ll, h)
+}
+
+// Return the location and height above the DefaultEllipsoid of p.
+func (p ECEF) LatLon() (LatLon, float64) {
+	return DefaultEllipsoid.FromECEF(p)
+}
+
+// Return the ECEF coordinates of ll, h meters above ellipsoid ell.
+func (ell Ellipsoid) ToECEF(ll LatLon, h float64) ECEF {
+	e2 := ell.F * (2.0 - ell.F)
+	sphi, cphi := sincosdx(ll.Lat)
+	slam, clam := sincosdx(ll.Lon)
+	// prime vertical radius of curvature
+	n := ell.A / math.Sqrt(1.0-e2*sphi*sphi)
+	return ECEF{
+		X: (n + h) * cphi * clam,
+		Y: (n + h) * cphi * slam,
+		Z: (n*(1.0-e2) + h) * sphi,
+	}
+}
+
+// Return the location and height above ellipsoid ell of p, by
+// Heikkinen's closed form solution.
+func (ell Ellipsoid) FromECEF(p ECEF) (LatLon, float64) {
+	a := ell.A
+	b := ell.B()
+	e2 := ell.F * (2.0 - ell.F)
+	ep2 := (a*a - b*b) / (b * b)
+
+	r := math.Hypot(p.X, p.Y)
+	if r == 0.0 {
+		// on the polar axis
+		lat := math.Copysign(90.0, p.Z)
+		return LatLon{Lat: lat, Lon: 0.0}, math.Abs(p.Z) - b
+	}
+
+	z2 := p.Z * p.Z
+	f := 54.0 * b * b * z2
+	g := r*r + (1.0-e2)*z2 - e2*(a*a-b*b)
+	c := e2 * e2 * f * r * r / (g * g * g)
+	s := math.Cbrt(1.0 + c + math.Sqrt(c*c+2.0*c))
+	k := s + 1.0 + 1.0/s
+	pp := f / (3.0 * k * k * g * g)
+	q := math.Sqrt(1.0 + 2.0*e2*e2*pp)
+	r0 := -pp*e2*r/(1.0+q) +
+		math.Sqrt(math.Max(0.0, a*a/2.0*(1.0+1.0/q)-pp*(1.0-e2)*z2/(q*(1.0+q))-pp*r*r/2.0))
+	t := r - e2*r0
+	u := math.Sqrt(t*t + z2)
+	v := math.Sqrt(t*t + (1.0-e2)*z2)
+	z0 := b * b * p.Z / (a * v)
+
+	var ret LatLon
+	ret.Lat = math.Atan2(p.Z+ep2*z0, r) / deg2rad
+	ret.Lon = math.Atan2(p.Y, p.X) / deg2rad
+	return ret, u * (1.0 - b*b/(a*v))
+}
+
+// A local east-north-up frame with its origin at a station.
+type LocalFrame struct {
+	Origin LatLon
+	Height float64 // meters above the ellipsoid
+
+	ell    Ellipsoid
+	center ECEF
+	east   ECEF // unit vectors of the frame, in ECEF
+	north  ECEF
+	up     ECEF
+}
+
+// Create the east-north-up frame at origin, h meters above the
+// DefaultEllipsoid.
+func NewLocalFrame(origin LatLon, h float64) LocalFrame {
+	return DefaultEllipsoid.LocalFrame(origin, h)
+}
+
+// Create the east-north-up frame at origin, h meters above ellipsoid ell.
+func (ell Ellipsoid) LocalFrame(origin LatLon, h float64) LocalFrame {
+	sphi, cphi := sincosdx(origin.Lat)
+	slam, clam := sincosdx(origin.Lon)
+	return LocalFrame{Origin: origin, Height: h, ell: ell,
+		center: ell.ToECEF(origin, h),
+		east:   ECEF{-slam, clam, 0.0},
+		north:  ECEF{-sphi * clam, -sphi * slam, cphi},
+		up:     ECEF{cphi * clam, cphi * slam, sphi},
+	}
+}
+
+// Return the position of ECEF point p in the frame.
+func (f LocalFrame) ENU(p ECEF) ENU {
+	dx, dy, dz := p.X-f.center.X, p.Y-f.center.Y, p.Z-f.center.Z
+	return ENU{
+		E: f.east.X*dx + f.east.Y*dy + f.east.Z*dz,
+		N: f.north.X*dx + f.north.Y*dy + f.north.Z*dz,
+		U: f.up.X*dx + f.up.Y*dy + f.up.Z*dz,
+	}
+}
+
+// Return the ECEF coordinates of point v in the frame.
+func (f LocalFrame) ECEF(v ENU) ECEF {
+	return ECEF{
+		X: f.center.X + f.east.X*v.E + f.north.X*v.N + f.up.X*v.U,
+		Y: f.center.Y + f.east.Y*v.E + f.north.Y*v.N + f.up.Y*v.U,
+		Z: f.center.Z + f.east.Z*v.E + f.north.Z*v.N + f.up.Z*v.U,
+	}
+}
+
+// Return the position in the frame of ll, h meters above the ellipsoid.
+func (f LocalFrame) ToENU(ll LatLon, h float64) ENU {
+	return f.ENU(f.ell.ToECEF(ll, h))
+}
+
+// Return the location and height above the ellipsoid of point v in
+// the frame.
+func (f LocalFrame) FromENU(v ENU) (LatLon, float64) {
+	return f.ell.FromECEF(f.ECEF(v))
+}
+
+// Return the azimuth (degrees clockwise from north, 0..360), elevation
+// (degrees above the local horizontal) and slant range (meters) from
+// the frame's origin to ll, h meters above the ellipsoid.
+func (f LocalFrame) Look(ll LatLon, h float64) (float64, float64, float64) {
+	v := f.ToENU(ll, h)
+	return v.Azimuth(), v.Elevation(), v.Range()
+}
+
+// Return the azimuth of v in degrees clockwise from north, 0..360.
+func (v ENU) Azimuth() float64 {
+	return azimuth360(math.Atan2(v.E, v.N) / deg2rad)
+}
+
+// Return the elevation of v in degrees above the local horizontal.
+func (v ENU) Elevation() float64 {
+	return math.Atan2(v.U, math.Hypot(v.E, v.N)) / deg2rad
+}
+
+// Return the distance from the origin to v in meters.
+func (v ENU) Range() float64 {
+	return math.Sqrt(v.E*v.E + v.N*v.N + v.U*v.U)
+}
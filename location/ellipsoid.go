@@ -0,0 +1,56 @@
+/*
+Copyright (c) 2012, Matthew H. Reilly (kb1vc)
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are
+met:
+
+    Redistributions of source code must retain the above copyright
+    notice, this list of conditions and the following disclaimer.
+    Redistributions in binary form must reproduce the above copyright
+    notice, this list of conditions and the following disclaimer in
+    the documentation and/or other materials provided with the
+    distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+"AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+// Reference ellipsoids for the geodetic calculations
+package location
+
+// An ellipsoid of revolution that models the shape of the earth.
+type Ellipsoid struct {
+	Name string
+	A    float64 // semi-major (equatorial) axis in meters
+	F    float64 // flattening (A - B) / A
+}
+
+// The GPS reference ellipsoid.
+var WGS84 = Ellipsoid{Name: "WGS84", A: 6378137.0, F: 1.0 / 298.257223563}
+
+// The reference ellipsoid for NAD83, the horizontal datum of the NED
+// elevation data. It differs from WGS84 by about 0.1 mm in the polar axis.
+var GRS80 = Ellipsoid{Name: "GRS80", A: 6378137.0, F: 1.0 / 298.257222101}
+
+// The reference ellipsoid for NAD27, used by the original
+// W9IP/N1BWT bearing and distance code.
+var Clarke1866 = Ellipsoid{Name: "Clarke 1866", A: 6378206.4, F: 1.0 - 6356583.8/6378206.4}
+
+// The ellipsoid used by LatLon.Bearing and LatLon.OnPath.
+var DefaultEllipsoid = WGS84
+
+// Return the semi-minor (polar) axis in meters.
+func (e Ellipsoid) B() float64 {
+	return e.A * (1.0 - e.F)
+}
@@ -0,0 +1,880 @@
+/*
+Copyright (c) 2012, Matthew H. Reilly (kb1vc)
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are
+met:
+
+    Redistributions of source code must retain the above copyright
+    notice, this list of conditions and the following disclaimer.
+    Redistributions in binary form must reproduce the above copyright
+    notice, this list of conditions and the following disclaimer in
+    the documentation and/or other materials provided with the
+    distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+"AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+// Geodesics on an ellipsoid after C. F. F. Karney, "Algorithms for
+// geodesics", J. Geodesy 87, 43-55 (2013).
+//
+// This follows the structure (and the variable names) of Karney's
+// GeographicLib C implementation, with the series truncated at sixth
+// order, which is accurate to a few nanometers for the earth. Unlike the
+// W9IP/N1BWT and Vincenty methods in bearing.go, the inverse solution
+// converges for every pair of points, including antipodal ones.
+// Only oblate ellipsoids (F >= 0) are supported.
+package location
+
+import (
+	"math"
+)
+
+// The solution of a geodesic problem: the shortest path between
+// two points on an ellipsoid.
+type Geodesic struct {
+	From          LatLon
+	To            LatLon
+	Azimuth1      float64 // bearing of the path at From in degrees clockwise from north (0..360)
+	Azimuth2      float64 // bearing of the path at To, continuing in the direction of travel
+	Dist          float64 // length of the path in km
+	Arc           float64 // length of the path on the auxiliary sphere in degrees
+	ReducedLength float64 // reduced length of the path in km
+}
+
+// Return the bearing from To back to From in degrees.
+func (g Geodesic) ReverseAzimuth() float64 {
+	return azimuth360(g.Azimuth2 + 180.0)
+}
+
+// reduce an azimuth in degrees to 0..360
+func azimuth360(x float64) float64 {
+	x = math.Mod(x, 360.0)
+	if x < 0.0 {
+		x += 360.0
+	}
+	if x >= 360.0 {
+		x = 0.0
+	}
+	return x + 0.0
+}
+
+const (
+	nA1  = 6
+	nC1  = 6
+	nC1p = 6
+	nA2  = 6
+	nC2  = 6
+	nA3  = 6
+	nA3x = nA3
+	nC3  = 6
+	nC3x = (nC3 * (nC3 - 1)) / 2
+	nC   = 7
+
+	qd = 90.0
+	hd = 180.0
+
+	maxit1 = 20
+	maxit2 = maxit1 + 53 + 10
+)
+
+var (
+	tol0    = math.Nextafter(1.0, 2.0) - 1.0
+	tol1    = 200.0 * tol0
+	tol2    = math.Sqrt(tol0)
+	tolb    = tol0 * tol2
+	xthresh = 1000.0 * tol2
+	tiny    = math.Sqrt(math.SmallestNonzeroFloat64 * (1 << 52))
+)
+
+// Constants of an ellipsoid for the geodesic calculations.
+type geodesic struct {
+	a, f, f1, e2, ep2, n, b, etol2 float64
+	A3x                            [nA3x]float64
+	C3x                            [nC3x]float64
+}
+
+func newGeodesic(ell Ellipsoid) *geodesic {
+	g := &geodesic{a: ell.A, f: ell.F}
+	g.f1 = 1.0 - g.f
+	g.e2 = g.f * (2.0 - g.f)
+	g.ep2 = g.e2 / (g.f1 * g.f1)
+	g.n = g.f / (2.0 - g.f)
+	g.b = g.a * g.f1
+	g.etol2 = 0.1 * tol2 / math.Sqrt(math.Max(0.001, math.Abs(g.f))*math.Min(1.0, 1.0-g.f/2.0)/2.0)
+	g.a3coeff()
+	g.c3coeff()
+	return g
+}
+
+// evaluate the polynomial of order n with coefficients p (highest first) at x
+func polyval(n int, p []float64, x float64) float64 {
+	if n < 0 {
+		return 0.0
+	}
+	y := p[0]
+	for i := 1; i <= n; i++ {
+		y = y*x + p[i]
+	}
+	return y
+}
+
+func (g *geodesic) a3coeff() {
+	coeff := []float64{
+		-3, 128,
+		-2, -3, 64,
+		-1, -3, -1, 16,
+		3, -1, -2, 8,
+		1, -1, 2,
+		1, 1,
+	}
+	o, k := 0, 0
+	for j := nA3 - 1; j >= 0; j-- {
+		m := j
+		if nA3-j-1 < j {
+			m = nA3 - j - 1
+		}
+		g.A3x[k] = polyval(m, coeff[o:], g.n) / coeff[o+m+1]
+		k++
+		o += m + 2
+	}
+}
+
+func (g *geodesic) c3coeff() {
+	coeff := []float64{
+		3, 128,
+		2, 5, 128,
+		-1, 3, 3, 64,
+		-1, 0, 1, 8,
+		-1, 1, 4,
+		5, 256,
+		1, 3, 128,
+		-3, -2, 3, 64,
+		1, -3, 2, 32,
+		7, 512,
+		-10, 9, 384,
+		5, -9, 5, 192,
+		7, 512,
+		-14, 7, 512,
+		21, 2560,
+	}
+	o, k := 0, 0
+	for l := 1; l < nC3; l++ {
+		for j := nC3 - 1; j >= l; j-- {
+			m := j
+			if nC3-j-1 < j {
+				m = nC3 - j - 1
+			}
+			g.C3x[k] = polyval(m, coeff[o:], g.n) / coeff[o+m+1]
+			k++
+			o += m + 2
+		}
+	}
+}
+
+func (g *geodesic) a3f(eps float64) float64 {
+	return polyval(nA3-1, g.A3x[:], eps)
+}
+
+func (g *geodesic) c3f(eps float64, c []float64) {
+	mult := 1.0
+	o := 0
+	for l := 1; l < nC3; l++ {
+		m := nC3 - l - 1
+		mult *= eps
+		c[l] = mult * polyval(m, g.C3x[o:], eps)
+		o += m + 1
+	}
+}
+
+func a1m1f(eps float64) float64 {
+	coeff := []float64{1, 4, 64, 0, 256}
+	m := nA1 / 2
+	t := polyval(m, coeff, eps*eps) / coeff[m+1]
+	return (t + eps) / (1.0 - eps)
+}
+
+func c1f(eps float64, c []float64) {
+	coeff := []float64{
+		-1, 6, -16, 32,
+		-9, 64, -128, 2048,
+		9, -16, 768,
+		3, -5, 512,
+		-7, 1280,
+		-7, 2048,
+	}
+	eps2 := eps * eps
+	d := eps
+	o := 0
+	for l := 1; l <= nC1; l++ {
+		m := (nC1 - l) / 2
+		c[l] = d * polyval(m, coeff[o:], eps2) / coeff[o+m+1]
+		o += m + 2
+		d *= eps
+	}
+}
+
+func c1pf(eps float64, c []float64) {
+	coeff := []float64{
+		205, -432, 768, 1536,
+		4005, -4736, 3840, 12288,
+		-225, 116, 384,
+		-7173, 2695, 7680,
+		3467, 7680,
+		38081, 61440,
+	}
+	eps2 := eps * eps
+	d := eps
+	o := 0
+	for l := 1; l <= nC1p; l++ {
+		m := (nC1p - l) / 2
+		c[l] = d * polyval(m, coeff[o:], eps2) / coeff[o+m+1]
+		o += m + 2
+		d *= eps
+	}
+}
+
+func a2m1f(eps float64) float64 {
+	coeff := []float64{-11, -28, -192, 0, 256}
+	m := nA2 / 2
+	t := polyval(m, coeff, eps*eps) / coeff[m+1]
+	return (t - eps) / (1.0 + eps)
+}
+
+func c2f(eps float64, c []float64) {
+	coeff := []float64{
+		1, 2, 16, 32,
+		35, 64, 384, 2048,
+		15, 80, 768,
+		7, 35, 512,
+		63, 1280,
+		77, 2048,
+	}
+	eps2 := eps * eps
+	d := eps
+	o := 0
+	for l := 1; l <= nC2; l++ {
+		m := (nC2 - l) / 2
+		c[l] = d * polyval(m, coeff[o:], eps2) / coeff[o+m+1]
+		o += m + 2
+		d *= eps
+	}
+}
+
+// Evaluate sum(c[i] * sin(2*i*x), i, 1, n) (sinp) or
+// sum(c[i] * cos((2*i+1)*x), i, 0, n-1) by Clenshaw summation.
+func sinCosSeries(sinp bool, sinx, cosx float64, c []float64, n int) float64 {
+	k := n
+	if sinp {
+		k++
+	}
+	ar := 2.0 * (cosx - sinx) * (cosx + sinx)
+	var y0, y1 float64
+	if n&1 != 0 {
+		k--
+		y0 = c[k]
+	}
+	for n /= 2; n > 0; n-- {
+		k--
+		y1 = ar*y0 - y1 + c[k]
+		k--
+		y0 = ar*y1 - y0 + c[k]
+	}
+	if sinp {
+		return 2.0 * sinx * cosx * y0
+	}
+	return cosx * (y0 - y1)
+}
+
+func norm2(s, c float64) (float64, float64) {
+	r := math.Hypot(s, c)
+	return s / r, c / r
+}
+
+// error free sum: u + v = s + t
+func sumx(u, v float64) (float64, float64) {
+	s := u + v
+	up := s - v
+	vpp := s - up
+	up -= u
+	vpp -= v
+	if s == 0 {
+		return s, s
+	}
+	return s, 0.0 - (up + vpp)
+}
+
+func angNormalize(x float64) float64 {
+	y := math.Remainder(x, 360.0)
+	if math.Abs(y) == hd {
+		return math.Copysign(hd, x)
+	}
+	return y
+}
+
+// the difference y - x, reduced to [-180, 180], and its rounding error
+func angDiff(x, y float64) (float64, float64) {
+	d, t := sumx(math.Remainder(-x, 360.0), math.Remainder(y, 360.0))
+	d, t = sumx(math.Remainder(d, 360.0), t)
+	if (d == 0.0) || (math.Abs(d) == hd) {
+		if t == 0.0 {
+			d = math.Copysign(d, y-x)
+		} else {
+			d = math.Copysign(d, -t)
+		}
+	}
+	return d, t
+}
+
+// round tiny angles so that the calculations near zero are exact
+func angRound(x float64) float64 {
+	const z = 1.0 / 16.0
+	y := math.Abs(x)
+	w := z - y
+	if w > 0 {
+		y = z - w
+	}
+	return math.Copysign(y, x)
+}
+
+func latFix(x float64) float64 {
+	if math.Abs(x) > qd {
+		return math.NaN()
+	}
+	return x
+}
+
+// sin and cos of x + t degrees, reducing x exactly to the first octant
+func sincosde(x, t float64) (float64, float64) {
+	q := math.RoundToEven(x / qd)
+	r := x - q*qd
+	r = angRound(r+t) * deg2rad
+	s, c := math.Sin(r), math.Cos(r)
+	var sinx, cosx float64
+	switch int64(q) & 3 {
+	case 0:
+		sinx, cosx = s, c
+	case 1:
+		sinx, cosx = c, -s
+	case 2:
+		sinx, cosx = -s, -c
+	default:
+		sinx, cosx = -c, s
+	}
+	cosx += 0.0
+	if sinx == 0 {
+		sinx = math.Copysign(sinx, x)
+	}
+	return sinx, cosx
+}
+
+func sincosdx(x float64) (float64, float64) {
+	return sincosde(x, 0.0)
+}
+
+// atan2 in degrees, accurate in every quadrant
+func atan2dx(y, x float64) float64 {
+	q := 0
+	if math.Abs(y) > math.Abs(x) {
+		x, y = y, x
+		q = 2
+	}
+	if math.Signbit(x) {
+		x = -x
+		q++
+	}
+	ang := math.Atan2(y, x) / deg2rad
+	switch q {
+	case 1:
+		ang = math.Copysign(hd, y) - ang
+	case 2:
+		ang = qd - ang
+	case 3:
+		ang = -qd + ang
+	}
+	return ang
+}
+
+// distance and reduced length (in units of b) over an arc sig12
+func (g *geodesic) lengths(eps, sig12, ssig1, csig1, dn1, ssig2, csig2, dn2 float64) (float64, float64) {
+	var ca, cb [nC]float64
+
+	a1 := a1m1f(eps)
+	c1f(eps, ca[:])
+	a2 := a2m1f(eps)
+	c2f(eps, cb[:])
+	m0 := a1 - a2
+	a2 = 1.0 + a2
+	a1 = 1.0 + a1
+
+	b1 := sinCosSeries(true, ssig2, csig2, ca[:], nC1) -
+		sinCosSeries(true, ssig1, csig1, ca[:], nC1)
+	s12b := a1 * (sig12 + b1)
+	b2 := sinCosSeries(true, ssig2, csig2, cb[:], nC2) -
+		sinCosSeries(true, ssig1, csig1, cb[:], nC2)
+	j12 := m0*sig12 + (a1*b1 - a2*b2)
+	m12b := dn2*(csig1*ssig2) - dn1*(ssig1*csig2) - csig1*csig2*j12
+
+	return s12b, m12b
+}
+
+// solve k^4+2*k^3-(x^2+y^2-1)*k^2-2*y^2*k-y^2 = 0 for the positive root k
+func astroid(x, y float64) float64 {
+	p := x * x
+	q := y * y
+	r := (p + q - 1.0) / 6.0
+	if (q == 0) && (r <= 0) {
+		return 0.0
+	}
+
+	s := p * q / 4.0
+	r2 := r * r
+	r3 := r * r2
+	disc := s * (s + 2.0*r3)
+	u := r
+	if disc >= 0 {
+		t3 := s + r3
+		if t3 < 0 {
+			t3 -= math.Sqrt(disc)
+		} else {
+			t3 += math.Sqrt(disc)
+		}
+		t := math.Cbrt(t3)
+		u += t
+		if t != 0 {
+			u += r2 / t
+		}
+	} else {
+		ang := math.Atan2(math.Sqrt(-disc), -(s + r3))
+		u += 2.0 * r * math.Cos(ang/3.0)
+	}
+	v := math.Sqrt(u*u + q)
+	var uv float64
+	if u < 0 {
+		uv = q / (v - u)
+	} else {
+		uv = u + v
+	}
+	w := (uv - q) / (2.0 * v)
+	return uv / (math.Sqrt(uv+w*w) + w)
+}
+
+// a starting point for Newton's method in the inverse problem. Returns
+// sig12 >= 0 (and alp2 and dnm) if the path is short enough to be
+// solved directly.
+func (g *geodesic) inverseStart(sbet1, cbet1, sbet2, cbet2, lam12, slam12, clam12 float64) (
+	sig12, salp1, calp1, salp2, calp2, dnm float64) {
+
+	sig12 = -1.0
+	sbet12 := sbet2*cbet1 - cbet2*sbet1
+	cbet12 := cbet2*cbet1 + sbet2*sbet1
+	sbet12a := sbet2*cbet1 + cbet2*sbet1
+	shortline := (cbet12 >= 0) && (sbet12 < 0.5) && (cbet2*lam12 < 0.5)
+
+	var somg12, comg12 float64
+	if shortline {
+		sbetm2 := (sbet1 + sbet2) * (sbet1 + sbet2)
+		sbetm2 /= sbetm2 + (cbet1+cbet2)*(cbet1+cbet2)
+		dnm = math.Sqrt(1.0 + g.ep2*sbetm2)
+		omg12 := lam12 / (g.f1 * dnm)
+		somg12, comg12 = math.Sin(omg12), math.Cos(omg12)
+	} else {
+		somg12, comg12 = slam12, clam12
+	}
+
+	salp1 = cbet2 * somg12
+	if comg12 >= 0 {
+		calp1 = sbet12 + cbet2*sbet1*somg12*somg12/(1.0+comg12)
+	} else {
+		calp1 = sbet12a - cbet2*sbet1*somg12*somg12/(1.0-comg12)
+	}
+
+	ssig12 := math.Hypot(salp1, calp1)
+	csig12 := sbet1*sbet2 + cbet1*cbet2*comg12
+
+	if shortline && (ssig12 < g.etol2) {
+		salp2 = cbet1 * somg12
+		if comg12 >= 0 {
+			calp2 = sbet12 - cbet1*sbet2*somg12*somg12/(1.0+comg12)
+		} else {
+			calp2 = sbet12 - cbet1*sbet2*(1.0-comg12)
+		}
+		salp2, calp2 = norm2(salp2, calp2)
+		sig12 = math.Atan2(ssig12, csig12)
+	} else if (math.Abs(g.n) > 0.1) || (csig12 >= 0) ||
+		(ssig12 >= 6.0*math.Abs(g.n)*math.Pi*cbet1*cbet1) {
+		// the zeroth order spherical approximation is good enough
+	} else {
+		// nearly antipodal: use the astroid solution
+		lam12x := math.Atan2(-slam12, -clam12)
+		k2 := sbet1 * sbet1 * g.ep2
+		eps := k2 / (2.0*(1.0+math.Sqrt(1.0+k2)) + k2)
+		lamscale := g.f * cbet1 * g.a3f(eps) * math.Pi
+		betscale := lamscale * cbet1
+		x := lam12x / lamscale
+		y := sbet12a / betscale
+
+		if (y > -tol1) && (x > -1.0-xthresh) {
+			salp1 = math.Min(1.0, -x)
+			calp1 = -math.Sqrt(1.0 - salp1*salp1)
+		} else {
+			k := astroid(x, y)
+			omg12a := lamscale * (-x * k / (1.0 + k))
+			somg12, comg12 = math.Sin(omg12a), -math.Cos(omg12a)
+			salp1 = cbet2 * somg12
+			calp1 = sbet12a - cbet2*sbet1*somg12*somg12/(1.0-comg12)
+		}
+	}
+
+	if !(salp1 <= 0) {
+		salp1, calp1 = norm2(salp1, calp1)
+	} else {
+		salp1, calp1 = 1.0, 0.0
+	}
+	return
+}
+
+// the working values of one Newton step
+type lambdaResult struct {
+	lam12, salp2, calp2, sig12              float64
+	ssig1, csig1, ssig2, csig2, eps, dlam12 float64
+}
+
+// the longitude difference for a path that leaves point 1 at alp1,
+// less the target difference lam120, and its derivative with respect to alp1
+func (g *geodesic) lambda12(sbet1, cbet1, dn1, sbet2, cbet2, dn2, salp1, calp1,
+	slam120, clam120 float64, diffp bool) lambdaResult {
+
+	var r lambdaResult
+	var ca [nC]float64
+
+	if (sbet1 == 0) && (calp1 == 0) {
+		calp1 = -tiny
+	}
+
+	salp0 := salp1 * cbet1
+	calp0 := math.Hypot(calp1, salp1*sbet1)
+
+	r.ssig1 = sbet1
+	somg1 := salp0 * sbet1
+	r.csig1 = calp1 * cbet1
+	comg1 := r.csig1
+	r.ssig1, r.csig1 = norm2(r.ssig1, r.csig1)
+
+	if cbet2 != cbet1 {
+		r.salp2 = salp0 / cbet2
+	} else {
+		r.salp2 = salp1
+	}
+	if (cbet2 != cbet1) || (math.Abs(sbet2) != -sbet1) {
+		var t float64
+		if cbet1 < -sbet1 {
+			t = (cbet2 - cbet1) * (cbet1 + cbet2)
+		} else {
+			t = (sbet1 - sbet2) * (sbet1 + sbet2)
+		}
+		r.calp2 = math.Sqrt((calp1*cbet1)*(calp1*cbet1)+t) / cbet2
+	} else {
+		r.calp2 = math.Abs(calp1)
+	}
+
+	r.ssig2 = sbet2
+	somg2 := salp0 * sbet2
+	r.csig2 = r.calp2 * cbet2
+	comg2 := r.csig2
+	r.ssig2, r.csig2 = norm2(r.ssig2, r.csig2)
+
+	r.sig12 = math.Atan2(math.Max(0.0, r.csig1*r.ssig2-r.ssig1*r.csig2)+0.0,
+		r.csig1*r.csig2+r.ssig1*r.ssig2)
+
+	somg12 := math.Max(0.0, comg1*somg2-somg1*comg2) + 0.0
+	comg12 := comg1*comg2 + somg1*somg2
+	eta := math.Atan2(somg12*clam120-comg12*slam120, comg12*clam120+somg12*slam120)
+	k2 := calp0 * calp0 * g.ep2
+	r.eps = k2 / (2.0*(1.0+math.Sqrt(1.0+k2)) + k2)
+	g.c3f(r.eps, ca[:])
+	b312 := sinCosSeries(true, r.ssig2, r.csig2, ca[:], nC3-1) -
+		sinCosSeries(true, r.ssig1, r.csig1, ca[:], nC3-1)
+	domg12 := -g.f * g.a3f(r.eps) * salp0 * (r.sig12 + b312)
+	r.lam12 = eta + domg12
+
+	if diffp {
+		if r.calp2 == 0 {
+			r.dlam12 = -2.0 * g.f1 * dn1 / sbet1
+		} else {
+			_, r.dlam12 = g.lengths(r.eps, r.sig12, r.ssig1, r.csig1, dn1, r.ssig2, r.csig2, dn2)
+			r.dlam12 *= g.f1 / (r.calp2 * cbet2)
+		}
+	}
+	return r
+}
+
+// Solve the inverse geodesic problem: find the shortest path from
+// fr to to on ellipsoid ell.
+func (ell Ellipsoid) Inverse(fr, to LatLon) Geodesic {
+	g := newGeodesic(ell)
+	lat1, lon1, lat2, lon2 := fr.Lat, fr.Lon, to.Lat, to.Lon
+
+	var s12x, m12x, a12, sig12 float64
+	var salp1, calp1, salp2, calp2 float64
+
+	lon12, lon12s := angDiff(lon1, lon2)
+	lonsign := 1.0
+	if math.Signbit(lon12) {
+		lonsign = -1.0
+	}
+	lon12 *= lonsign
+	lon12s *= lonsign
+	lam12 := lon12 * deg2rad
+	slam12, clam12 := sincosde(lon12, lon12s)
+	lon12s = (hd - lon12) - lon12s
+
+	lat1 = angRound(latFix(lat1))
+	lat2 = angRound(latFix(lat2))
+	swapp := 1.0
+	if (math.Abs(lat1) < math.Abs(lat2)) || math.IsNaN(lat2) {
+		swapp = -1.0
+		lonsign *= -1.0
+		lat1, lat2 = lat2, lat1
+	}
+	latsign := -1.0
+	if math.Signbit(lat1) {
+		latsign = 1.0
+	}
+	lat1 *= latsign
+	lat2 *= latsign
+
+	sbet1, cbet1 := sincosdx(lat1)
+	sbet1 *= g.f1
+	sbet1, cbet1 = norm2(sbet1, cbet1)
+	cbet1 = math.Max(tiny, cbet1)
+
+	sbet2, cbet2 := sincosdx(lat2)
+	sbet2 *= g.f1
+	sbet2, cbet2 = norm2(sbet2, cbet2)
+	cbet2 = math.Max(tiny, cbet2)
+
+	if cbet1 < -sbet1 {
+		if cbet2 == cbet1 {
+			sbet2 = math.Copysign(sbet1, sbet2)
+		}
+	} else if math.Abs(sbet2) == -sbet1 {
+		cbet2 = cbet1
+	}
+
+	dn1 := math.Sqrt(1.0 + g.ep2*sbet1*sbet1)
+	dn2 := math.Sqrt(1.0 + g.ep2*sbet2*sbet2)
+
+	meridian := (lat1 == -qd) || (slam12 == 0)
+	if meridian {
+		// the path may run along a meridian
+		calp1, salp1 = clam12, slam12
+		calp2, salp2 = 1.0, 0.0
+
+		ssig1, csig1 := sbet1, calp1*cbet1
+		ssig2, csig2 := sbet2, calp2*cbet2
+
+		sig12 = math.Atan2(math.Max(0.0, csig1*ssig2-ssig1*csig2)+0.0, csig1*csig2+ssig1*ssig2)
+		s12x, m12x = g.lengths(g.n, sig12, ssig1, csig1, dn1, ssig2, csig2, dn2)
+		if (sig12 < 1) || (m12x >= 0) {
+			if (sig12 < 3*tiny) || ((sig12 < tol0) && ((s12x < 0) || (m12x < 0))) {
+				sig12, m12x, s12x = 0, 0, 0
+			}
+			m12x *= g.b
+			s12x *= g.b
+			a12 = sig12 / deg2rad
+		} else {
+			meridian = false
+		}
+	}
+
+	if !meridian && (sbet1 == 0) && ((g.f <= 0) || (lon12s >= g.f*hd)) {
+		// the path runs along the equator
+		calp1, calp2 = 0.0, 0.0
+		salp1, salp2 = 1.0, 1.0
+		s12x = g.a * lam12
+		sig12 = lam12 / g.f1
+		m12x = g.b * math.Sin(sig12)
+		a12 = lon12 / g.f1
+	} else if !meridian {
+		var dnm float64
+		sig12, salp1, calp1, salp2, calp2, dnm = g.inverseStart(sbet1, cbet1, sbet2, cbet2, lam12, slam12, clam12)
+
+		if sig12 >= 0 {
+			// short lines
+			s12x = sig12 * g.b * dnm
+			m12x = dnm * dnm * g.b * math.Sin(sig12/dnm)
+			a12 = sig12 / deg2rad
+		} else {
+			// Newton's method on lambda12(alp1) - lam12 = 0, keeping
+			// a bracket around the root to fall back on
+			var r lambdaResult
+			salp1a, calp1a := tiny, 1.0
+			salp1b, calp1b := tiny, -1.0
+			tripn, tripb := false, false
+			for numit := 0; ; numit++ {
+				r = g.lambda12(sbet1, cbet1, dn1, sbet2, cbet2, dn2, salp1, calp1,
+					slam12, clam12, numit < maxit1)
+				v, dv := r.lam12, r.dlam12
+				lim := 1.0
+				if tripn {
+					lim = 8.0
+				}
+				if tripb || !(math.Abs(v) >= lim*tol0) || (numit == maxit2) {
+					break
+				}
+				if (v > 0) && ((numit > maxit1) || (calp1/salp1 > calp1b/salp1b)) {
+					salp1b, calp1b = salp1, calp1
+				} else if (v < 0) && ((numit > maxit1) || (calp1/salp1 < calp1a/salp1a)) {
+					salp1a, calp1a = salp1, calp1
+				}
+				if (numit < maxit1) && (dv > 0) {
+					dalp1 := -v / dv
+					if math.Abs(dalp1) < math.Pi {
+						sdalp1, cdalp1 := math.Sin(dalp1), math.Cos(dalp1)
+						nsalp1 := salp1*cdalp1 + calp1*sdalp1
+						if nsalp1 > 0 {
+							calp1 = calp1*cdalp1 - salp1*sdalp1
+							salp1 = nsalp1
+							salp1, calp1 = norm2(salp1, calp1)
+							tripn = math.Abs(v) <= 16.0*tol0
+							continue
+						}
+					}
+				}
+				// bisect
+				salp1 = (salp1a + salp1b) / 2.0
+				calp1 = (calp1a + calp1b) / 2.0
+				salp1, calp1 = norm2(salp1, calp1)
+				tripn = false
+				tripb = (math.Abs(salp1a-salp1)+(calp1a-calp1) < tolb) ||
+					(math.Abs(salp1-salp1b)+(calp1-calp1b) < tolb)
+			}
+			salp2, calp2, sig12 = r.salp2, r.calp2, r.sig12
+			s12x, m12x = g.lengths(r.eps, sig12, r.ssig1, r.csig1, dn1, r.ssig2, r.csig2, dn2)
+			m12x *= g.b
+			s12x *= g.b
+			a12 = sig12 / deg2rad
+		}
+	}
+
+	if swapp < 0 {
+		salp1, salp2 = salp2, salp1
+		calp1, calp2 = calp2, calp1
+	}
+	salp1 *= swapp * lonsign
+	calp1 *= swapp * latsign
+	salp2 *= swapp * lonsign
+	calp2 *= swapp * latsign
+
+	return Geodesic{From: fr, To: to,
+		Azimuth1: azimuth360(atan2dx(salp1, calp1)), Azimuth2: azimuth360(atan2dx(salp2, calp2)),
+		Dist: (0.0 + s12x) / 1000.0, Arc: a12, ReducedLength: (0.0 + m12x) / 1000.0}
+}
+
+// Solve the direct geodesic problem: find where the path that leaves
+// fr at azimuth az degrees ends up after dist km on ellipsoid ell.
+func (ell Ellipsoid) Direct(fr LatLon, az, dist float64) Geodesic {
+	g := newGeodesic(ell)
+	var c1a, c1pa, c2a, c3a [nC]float64
+
+	lat1 := latFix(fr.Lat)
+	azi1 := angNormalize(az)
+	salp1, calp1 := sincosdx(angRound(azi1))
+
+	sbet1, cbet1 := sincosdx(angRound(lat1))
+	sbet1 *= g.f1
+	sbet1, cbet1 = norm2(sbet1, cbet1)
+	cbet1 = math.Max(tiny, cbet1)
+	dn1 := math.Sqrt(1.0 + g.ep2*sbet1*sbet1)
+
+	salp0 := salp1 * cbet1
+	calp0 := math.Hypot(calp1, salp1*sbet1)
+
+	ssig1 := sbet1
+	somg1 := salp0 * sbet1
+	csig1 := 1.0
+	if (sbet1 != 0) || (calp1 != 0) {
+		csig1 = cbet1 * calp1
+	}
+	comg1 := csig1
+	ssig1, csig1 = norm2(ssig1, csig1)
+
+	k2 := calp0 * calp0 * g.ep2
+	eps := k2 / (2.0*(1.0+math.Sqrt(1.0+k2)) + k2)
+
+	a1m1 := a1m1f(eps)
+	c1f(eps, c1a[:])
+	b11 := sinCosSeries(true, ssig1, csig1, c1a[:], nC1)
+	s, c := math.Sin(b11), math.Cos(b11)
+	stau1 := ssig1*c + csig1*s
+	ctau1 := csig1*c - ssig1*s
+
+	c1pf(eps, c1pa[:])
+
+	a2m1 := a2m1f(eps)
+	c2f(eps, c2a[:])
+	b21 := sinCosSeries(true, ssig1, csig1, c2a[:], nC2)
+
+	g.c3f(eps, c3a[:])
+	a3c := -g.f * salp0 * g.a3f(eps)
+	b31 := sinCosSeries(true, ssig1, csig1, c3a[:], nC3-1)
+
+	// now follow the path for dist km
+	s12 := dist * 1000.0
+	tau12 := s12 / (g.b * (1.0 + a1m1))
+	s, c = math.Sin(tau12), math.Cos(tau12)
+	b12 := -sinCosSeries(true, stau1*c+ctau1*s, ctau1*c-stau1*s, c1pa[:], nC1p)
+	sig12 := tau12 - (b12 - b11)
+	ssig12, csig12 := math.Sin(sig12), math.Cos(sig12)
+	if math.Abs(g.f) > 0.01 {
+		ssig2 := ssig1*csig12 + csig1*ssig12
+		csig2 := csig1*csig12 - ssig1*ssig12
+		b12 = sinCosSeries(true, ssig2, csig2, c1a[:], nC1)
+		serr := (1.0+a1m1)*(sig12+(b12-b11)) - s12/g.b
+		sig12 = sig12 - serr/math.Sqrt(1.0+k2*ssig2*ssig2)
+		ssig12, csig12 = math.Sin(sig12), math.Cos(sig12)
+	}
+
+	ssig2 := ssig1*csig12 + csig1*ssig12
+	csig2 := csig1*csig12 - ssig1*ssig12
+	dn2 := math.Sqrt(1.0 + k2*ssig2*ssig2)
+	if math.Abs(g.f) > 0.01 {
+		b12 = sinCosSeries(true, ssig2, csig2, c1a[:], nC1)
+	}
+	ab1 := (1.0 + a1m1) * (b12 - b11)
+
+	sbet2 := calp0 * ssig2
+	cbet2 := math.Hypot(salp0, calp0*csig2)
+	if cbet2 == 0 {
+		cbet2, csig2 = tiny, tiny
+	}
+	salp2, calp2 := salp0, calp0*csig2
+
+	somg2, comg2 := salp0*ssig2, csig2
+	omg12 := math.Atan2(somg2*comg1-comg2*somg1, comg2*comg1+somg2*somg1)
+	lam12 := omg12 + a3c*(sig12+(sinCosSeries(true, ssig2, csig2, c3a[:], nC3-1)-b31))
+	lon12 := lam12 / deg2rad
+	lon2 := angNormalize(angNormalize(fr.Lon) + angNormalize(lon12))
+	lat2 := atan2dx(sbet2, g.f1*cbet2)
+
+	b22 := sinCosSeries(true, ssig2, csig2, c2a[:], nC2)
+	ab2 := (1.0 + a2m1) * (b22 - b21)
+	j12 := (a1m1-a2m1)*sig12 + (ab1 - ab2)
+	m12 := g.b * ((dn2*(csig1*ssig2) - dn1*(ssig1*csig2)) - csig1*csig2*j12)
+
+	return Geodesic{From: fr, To: LatLon{Lat: lat2, Lon: lon2},
+		Azimuth1: azimuth360(azi1), Azimuth2: azimuth360(atan2dx(salp2, calp2)),
+		Dist: dist, Arc: sig12 / deg2rad, ReducedLength: m12 / 1000.0}
+}
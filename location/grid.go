@@ -30,17 +30,37 @@ OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 package location
 
 import (
-	"strings"
 	"errors"
 	"fmt"
 	"math"
+	"strings"
 )
 
-var positions = []string  { "first", "second", "third", "fourth", "fifth", "sixth", "seventh", "eighth" }
+// The longest locator FromGrid and ToGrid handle: field, square,
+// subsquare, extended square, extended subsquare and the 12 character
+// extension.
+const MaxGridChars = 12
+
+// One pair of characters in a locator. Pairs alternate between
+// letters and digits; each divides the square named by the pair
+// before it into base x base pieces.
+type gridPair struct {
+	base  int
+	first byte // the character for 0 (upper case for letters)
+}
+
+var gridPairs = []gridPair{
+	{18, 'A'},
+	{10, '0'},
+	{24, 'A'},
+	{10, '0'},
+	{24, 'A'},
+	{10, '0'},
+}
 
 func checkgrid(gs string, pos int, min byte, max byte) error {
 	for i := 0; i < 2; i++ {
-		if (gs[pos + i] < min) || (gs[pos + i] > max) {
+		if (gs[pos+i] < min) || (gs[pos+i] > max) {
 			erstr := fmt.Sprintf("Bad grid specification %s character was %c; must be in range [%c, %c] inclusive.", gs, gs[pos+i], min, max)
 			return errors.New(erstr)
 		}
@@ -48,98 +68,112 @@ func checkgrid(gs string, pos int, min byte, max byte) error {
 	return nil
 }
 
-func FromGrid(gs string) (LatLon, error) {
-	gs = strings.ToUpper(gs)
-	
-	var ret LatLon
-	
-	if er := checkgrid(gs, 0, 'A', 'R'); er != nil {
-		return ret, er
-	}
-
-	ret.Lon = 20.0 * float64(gs[0] - 'A') - 180.0
-	ret.Lat = 10.0 * float64(gs[1] - 'A') - 90.0
-
-	if len(gs) < 4 {
-		ret.Lon += 10.0;
-		ret.Lat += 5.0;
-		return ret, nil
+func checkPrecision(chars int) error {
+	if (chars < 2) || (chars > MaxGridChars) || (chars%2 != 0) {
+		return errors.New(fmt.Sprintf("Grid precision must be an even number of characters from 2 to %d, got %d", MaxGridChars, chars))
 	}
+	return nil
+}
 
-	if er := checkgrid(gs, 2, '0', '9'); er != nil {
-		return ret, er
+// the number of squares around the earth (and from pole to pole)
+// at a precision of chars characters
+func gridDivisions(chars int) int {
+	n := 1
+	for i := 0; i < chars/2; i++ {
+		n *= gridPairs[i].base
 	}
+	return n
+}
 
+// Locators are handled as the column and row of the square, counted
+// east from 180W and north from the south pole, among all the squares
+// of the locator's length.
 
-	ret.Lon += 2.0 * float64(gs[2] - '0')
-	ret.Lat += 1.0 * float64(gs[3] - '0')
+// the length, column and row of the square named by gs
+func parseGrid(gs string) (int, int, int, error) {
+	gs = strings.ToUpper(gs)
 
-	if len(gs) < 6 {
-		ret.Lon += 1.0
-		ret.Lat += 0.5
-		return ret, nil
-	}
-		
-	if er := checkgrid(gs, 4, 'A', 'X'); er != nil {
-		return ret, er
+	if (len(gs) < 2) || (len(gs) > MaxGridChars) || (len(gs)%2 != 0) {
+		erstr := fmt.Sprintf("Bad grid specification %q: must be an even number of characters from 2 to %d", gs, MaxGridChars)
+		return 0, 0, 0, errors.New(erstr)
 	}
 
+	col, row := 0, 0
+	for i := 0; i < len(gs); i += 2 {
+		gp := gridPairs[i/2]
+		if er := checkgrid(gs, i, gp.first, gp.first+byte(gp.base-1)); er != nil {
+			return 0, 0, 0, er
+		}
+		col = col*gp.base + int(gs[i]-gp.first)
+		row = row*gp.base + int(gs[i+1]-gp.first)
+	}
 
-	ret.Lon += (2.0/24.0) * float64(gs[4] - 'A')
-	ret.Lat += (1.0/24.0) * float64(gs[5] - 'A')
+	return len(gs), col, row, nil
+}
 
-	if len(gs) < 8 {
-		ret.Lon += (1.0 / 24.0)
-		ret.Lat += (0.5 / 24.0)
-		return ret, nil
+// the column and row of the square of chars characters containing ll;
+// the east edge (180E) and the north pole belong to the last square
+func gridIndex(ll LatLon, chars int) (int, int, error) {
+	if err := checkPrecision(chars); err != nil {
+		return 0, 0, err
 	}
-
-	if er := checkgrid(gs, 6, '0', '9'); er != nil {
-		return ret, er
+	if (ll.Lon < -180.0) || (ll.Lon > 180.0) ||
+		(ll.Lat < -90.0) || (ll.Lat > 90.0) {
+		erstr := fmt.Sprintf("Lat/Lon coordinate is out of range %f lat %f lon", ll.Lat, ll.Lon)
+		return 0, 0, errors.New(erstr)
 	}
 
-	ret.Lon += (0.2 / 24.0) * (float64(gs[6] - '0') + 0.5)
-	ret.Lat += (0.1 / 24.0) * (float64(gs[7] - '0') + 0.5)
+	n := gridDivisions(chars)
+	col := int(math.Floor((ll.Lon + 180.0) * float64(n) / 360.0))
+	row := int(math.Floor((ll.Lat + 90.0) * float64(n) / 180.0))
+	if col >= n {
+		col = n - 1
+	}
+	if row >= n {
+		row = n - 1
+	}
 
-	
-	return ret, nil
+	return col, row, nil
 }
 
-func ToGrid(ll LatLon) (string, error) {
-	var ret string
-	ret = "      "
+// the locator of the square at col, row; the first pair is upper
+// case, later letter pairs are lower case
+func gridString(chars, col, row int) string {
+	ret := make([]byte, chars)
+	for i := chars - 2; i >= 0; i -= 2 {
+		gp := gridPairs[i/2]
+		first := gp.first
+		if (i > 0) && (first == 'A') {
+			first = 'a'
+		}
+		ret[i] = first + byte(col%gp.base)
+		ret[i+1] = first + byte(row%gp.base)
+		col /= gp.base
+		row /= gp.base
+	}
+	return string(ret)
+}
 
-	if (ll.Lon < -180.0) || (ll.Lon > 180.0) || 
-		(ll.Lat < -90.0) || (ll.Lat > 90.0) {
-		erstr := fmt.Sprintf("Lat/Lon coordinate is out of range %f lat %f lon", ll.Lat, ll.Lon)
-		return ret, errors.New(erstr)
+// Return the location of the center of the square named by the
+// Maidenhead locator gs, which may be 2, 4, 6, 8, 10 or 12 characters
+// long. Case is ignored.
+func FromGrid(gs string) (LatLon, error) {
+	chars, col, row, err := parseGrid(gs)
+	if err != nil {
+		return LatLon{}, err
 	}
+	n := float64(gridDivisions(chars))
+	return LatLon{Lat: -90.0 + 180.0*(float64(row)+0.5)/n,
+		Lon: -180.0 + 360.0*(float64(col)+0.5)/n}, nil
+}
 
-	// find the lon chars. 
-	lon := ll.Lon + 180
-	offset := math.Floor(lon / 20.0)
-	lon = lon - 20.0 * offset
-	ret += string('A' + int(offset))
-
-	lat := ll.Lat + 90.0
-	offset = math.Floor(lat / 10.0)
-	lat = lat - 10.0 * offset
-	ret += string('A' + int(offset))
-
-	offset = math.Floor(lon / 2.0)
-	lon = lon - 2.0 * offset
-	ret += string('0' + int(offset))
-
-	offset = math.Floor(lat / 1.0)
-	lat = lat - 1.0 * offset
-	ret += string('0' + int(offset))
-	
-	offset = math.Floor(lon / (2.0 / 24.0))
-	ret += string('a' + int(offset))
-
-	offset = math.Floor(lat / (1.0 / 24.0))
-	ret += string('a' + int(offset))
-
-	return ret, nil
-	
+// Return the Maidenhead locator of the square containing ll, chars
+// characters long (2, 4, 6, 8, 10 or 12). The first pair is upper
+// case, later letter pairs are lower case: "FN42ai52xw".
+func ToGrid(ll LatLon, chars int) (string, error) {
+	col, row, err := gridIndex(ll, chars)
+	if err != nil {
+		return "", err
+	}
+	return gridString(chars, col, row), nil
 }
@@ -0,0 +1,228 @@
+/*
+Copyright (c) 2012, Matthew H. Reilly (kb1vc)
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are
+met:
+
+    Redistributions of source code must retain the above copyright
+    notice, this list of conditions and the following disclaimer.
+    Redistributions in binary form must reproduce the above copyright
+    notice, this list of conditions and the following disclaimer in
+    the documentation and/or other materials provided with the
+    distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+"AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package location
+
+import (
+	"errors"
+	"fmt"
+)
+
+// A Maidenhead grid square at some precision (2 to MaxGridChars
+// characters). The square is held as its column and row, counted
+// east from 180W and north from the south pole, among all squares
+// of that precision.
+type Grid struct {
+	chars int
+	col   int
+	row   int
+}
+
+// The directions to a Grid's neighbors.
+type Direction int
+
+const (
+	North Direction = iota
+	NorthEast
+	East
+	SouthEast
+	South
+	SouthWest
+	West
+	NorthWest
+)
+
+var directionSteps = [][2]int{
+	{0, 1}, {1, 1}, {1, 0}, {1, -1}, {0, -1}, {-1, -1}, {-1, 0}, {-1, 1},
+}
+
+// Return the grid square named by the Maidenhead locator gs.
+func ParseGrid(gs string) (Grid, error) {
+	chars, col, row, err := parseGrid(gs)
+	if err != nil {
+		return Grid{}, err
+	}
+	return Grid{chars: chars, col: col, row: row}, nil
+}
+
+// Return the grid square of chars characters that contains ll.
+// Points on the east edge of the map (180E) and at the north pole
+// belong to the last square.
+func GridAt(ll LatLon, chars int) (Grid, error) {
+	col, row, err := gridIndex(ll, chars)
+	if err != nil {
+		return Grid{}, err
+	}
+	return Grid{chars: chars, col: col, row: row}, nil
+}
+
+// Return the locator for the square; the first pair is upper case,
+// later letter pairs are lower case.
+func (g Grid) String() string {
+	return gridString(g.chars, g.col, g.row)
+}
+
+// Return the number of characters in the square's locator.
+func (g Grid) Precision() int {
+	return g.chars
+}
+
+// Return the height and width of the square in degrees.
+func (g Grid) Size() (float64, float64) {
+	n := float64(gridDivisions(g.chars))
+	return 180.0 / n, 360.0 / n
+}
+
+// the latitude and longitude at row and column r, c
+// (which may be fractional)
+func (g Grid) corner(r, c float64) LatLon {
+	n := float64(gridDivisions(g.chars))
+	return LatLon{Lat: -90.0 + 180.0*r/n, Lon: -180.0 + 360.0*c/n}
+}
+
+// Return the south-west corner of the square.
+func (g Grid) SW() LatLon {
+	return g.corner(float64(g.row), float64(g.col))
+}
+
+// Return the north-east corner of the square.
+func (g Grid) NE() LatLon {
+	return g.corner(float64(g.row+1), float64(g.col+1))
+}
+
+// Return the center of the square.
+func (g Grid) Center() LatLon {
+	return g.corner(float64(g.row)+0.5, float64(g.col)+0.5)
+}
+
+// Return true if ll is within the square (south and west edges
+// included).
+func (g Grid) Contains(ll LatLon) bool {
+	sq, err := GridAt(ll, g.chars)
+	return (err == nil) && (sq == g)
+}
+
+// Return the square next to g in direction dir. Squares wrap
+// around at the antimeridian; there is nothing north of the
+// northernmost row or south of the southernmost, so ok is false there.
+func (g Grid) Neighbor(dir Direction) (Grid, bool) {
+	n := gridDivisions(g.chars)
+	step := directionSteps[dir]
+	row := g.row + step[1]
+	if (row < 0) || (row >= n) {
+		return Grid{}, false
+	}
+	col := (g.col + step[0] + n) % n
+	return Grid{chars: g.chars, col: col, row: row}, true
+}
+
+// Return the squares that touch g, starting at the north and going
+// clockwise. Squares in the polar rows have only five neighbors.
+func (g Grid) Neighbors() []Grid {
+	ret := make([]Grid, 0, len(directionSteps))
+	for dir := North; dir <= NorthWest; dir++ {
+		if nb, ok := g.Neighbor(dir); ok {
+			ret = append(ret, nb)
+		}
+	}
+	return ret
+}
+
+// Return the square, two characters shorter, that contains g.
+// A 2 character field has no parent.
+func (g Grid) Parent() (Grid, bool) {
+	if g.chars <= 2 {
+		return Grid{}, false
+	}
+	base := gridPairs[g.chars/2-1].base
+	return Grid{chars: g.chars - 2, col: g.col / base, row: g.row / base}, true
+}
+
+// Return the squares, two characters longer, that make up g,
+// from the south-west corner eastward and then northward.
+// A square at MaxGridChars has no children.
+func (g Grid) Children() []Grid {
+	if g.chars >= MaxGridChars {
+		return nil
+	}
+	base := gridPairs[g.chars/2].base
+	ret := make([]Grid, 0, base*base)
+	for r := 0; r < base; r++ {
+		for c := 0; c < base; c++ {
+			ret = append(ret, Grid{chars: g.chars + 2, col: g.col*base + c, row: g.row*base + r})
+		}
+	}
+	return ret
+}
+
+// Call fn for each square of chars characters that intersects the
+// box with corners sw and ne, from the south-west corner eastward and
+// then northward, until fn returns false. A box with sw.Lon > ne.Lon
+// crosses the antimeridian.
+func EachGrid(sw, ne LatLon, chars int, fn func(Grid) bool) error {
+	if sw.Lat > ne.Lat {
+		return errors.New(fmt.Sprintf("Bounding box south edge %f is north of its north edge %f", sw.Lat, ne.Lat))
+	}
+	first, err := GridAt(sw, chars)
+	if err != nil {
+		return err
+	}
+	last, err := GridAt(ne, chars)
+	if err != nil {
+		return err
+	}
+
+	n := gridDivisions(chars)
+	cols := last.col - first.col + 1
+	if sw.Lon > ne.Lon {
+		cols += n
+	}
+	if cols > n {
+		cols = n
+	}
+
+	for row := first.row; row <= last.row; row++ {
+		for i := 0; i < cols; i++ {
+			if !fn(Grid{chars: chars, col: (first.col + i) % n, row: row}) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// Return all the squares of chars characters that intersect
+// the box with corners sw and ne.
+func GridsIn(sw, ne LatLon, chars int) ([]Grid, error) {
+	var ret []Grid
+	err := EachGrid(sw, ne, chars, func(g Grid) bool {
+		ret = append(ret, g)
+		return true
+	})
+	return ret, err
+}
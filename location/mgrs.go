@@ -0,0 +1,154 @@
+/*
+Copyright (c) 2012, Matthew H. Reilly (kb1vc)
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are
+met:
+
+    Redistributions of source code must retain the above copyright
+    notice, this list of conditions and the following disclaimer.
+    Redistributions in binary form must reproduce the above copyright
+    notice, this list of conditions and the following disclaimer in
+    the documentation and/or other materials provided with the
+    distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+"AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+// Military Grid Reference System coordinates, built on UTM.
+// The polar (UPS) regions are not supported.
+package location
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// the 100 km square column letters, by zone modulo 3
+var mgrsCols = []string{"ABCDEFGH", "JKLMNPQR", "STUVWXYZ"}
+
+// the 100 km square row letters; even zones start at F
+const mgrsRows = "ABCDEFGHJKLMNPQRSTUV"
+
+// Return the MGRS reference for ll on the DefaultEllipsoid with digits
+// (0 to 5) digits each of easting and northing, e.g. "19T CG 30000 90000".
+func ToMGRS(ll LatLon, digits int) (string, error) {
+	return DefaultEllipsoid.ToMGRS(ll, digits)
+}
+
+// Return the location of the center of the square named by the MGRS
+// reference s on the DefaultEllipsoid.
+func FromMGRS(s string) (LatLon, error) {
+	return DefaultEllipsoid.FromMGRS(s)
+}
+
+// Return the MGRS reference for ll on ellipsoid ell with digits
+// (0 to 5) digits each of easting and northing.
+func (ell Ellipsoid) ToMGRS(ll LatLon, digits int) (string, error) {
+	if (digits < 0) || (digits > 5) {
+		return "", errors.New(fmt.Sprintf("MGRS precision must be 0 to 5 digits, got %d", digits))
+	}
+	u, err := ell.ToUTM(ll)
+	if err != nil {
+		return "", err
+	}
+
+	// round off to a micron so that the truncation below does not
+	// turn 6650000 m into 6649999.99999
+	u.Easting = math.Round(u.Easting*1.0e6) / 1.0e6
+	u.Northing = math.Round(u.Northing*1.0e6) / 1.0e6
+
+	col := int(math.Floor(u.Easting / 100000.0))
+	row := int(math.Floor(u.Northing/100000.0)) % 20
+	if u.Zone%2 == 0 {
+		row = (row + 5) % 20
+	}
+	if (col < 1) || (col > 8) {
+		return "", errors.New(fmt.Sprintf("Easting %f is outside UTM zone %d", u.Easting, u.Zone))
+	}
+
+	ret := fmt.Sprintf("%d%c %c%c", u.Zone, u.Band, mgrsCols[(u.Zone-1)%3][col-1], mgrsRows[row])
+	if digits > 0 {
+		// MGRS references truncate, so that the square contains the point
+		scale := math.Pow(10.0, float64(5-digits))
+		e := math.Floor(math.Mod(u.Easting, 100000.0) / scale)
+		n := math.Floor(math.Mod(u.Northing, 100000.0) / scale)
+		ret += fmt.Sprintf(" %0*.0f %0*.0f", digits, e, digits, n)
+	}
+	return ret, nil
+}
+
+// Return the location of the center of the square named by the MGRS
+// reference s on ellipsoid ell. Spaces in s are ignored.
+func (ell Ellipsoid) FromMGRS(s string) (LatLon, error) {
+	ms := strings.ToUpper(strings.Join(strings.Fields(s), ""))
+	bad := func(why string) (LatLon, error) {
+		return LatLon{}, errors.New(fmt.Sprintf("Bad MGRS reference %q: %s", s, why))
+	}
+
+	i := 0
+	for (i < len(ms)) && (i < 2) && (ms[i] >= '0') && (ms[i] <= '9') {
+		i++
+	}
+	if (i == 0) || (len(ms) < i+3) {
+		return bad("expected zone, latitude band and 100 km square")
+	}
+	var u UTM
+	fmt.Sscanf(ms[:i], "%d", &u.Zone)
+	u.Band = ms[i]
+	if (u.Zone < 1) || (u.Zone > 60) {
+		return bad("zone must be 1 to 60")
+	}
+	if !strings.ContainsRune(utmBands, rune(u.Band)) {
+		return bad("latitude band must be C to X, without I or O")
+	}
+
+	col := strings.IndexByte(mgrsCols[(u.Zone-1)%3], ms[i+1])
+	row := strings.IndexByte(mgrsRows, ms[i+2])
+	if col < 0 {
+		return bad(fmt.Sprintf("100 km column %c is not used in zone %d", ms[i+1], u.Zone))
+	}
+	if row < 0 {
+		return bad(fmt.Sprintf("100 km row %c is not valid", ms[i+2]))
+	}
+	if u.Zone%2 == 0 {
+		row = (row + 15) % 20
+	}
+
+	num := ms[i+3:]
+	if (len(num)%2 != 0) || (len(num) > 10) || (strings.Trim(num, "0123456789") != "") {
+		return bad("expected an even number of digits, at most 10")
+	}
+	digits := len(num) / 2
+	scale := math.Pow(10.0, float64(5-digits))
+	var e, n float64
+	if digits > 0 {
+		fmt.Sscanf(num[:digits], "%f", &e)
+		fmt.Sscanf(num[digits:], "%f", &n)
+	}
+	u.Easting = float64(col+1)*100000.0 + (e+0.5)*scale
+	u.Northing = float64(row)*100000.0 + (n+0.5)*scale
+
+	// The row letters repeat every 2000 km: move north to the
+	// first repeat that is in the latitude band.
+	band := float64(strings.IndexByte(utmBands, u.Band))*8.0 + utmMinLat
+	bottom := ell.toUTMZone(LatLon{Lat: band, Lon: utmMeridian(u.Zone)}, u.Zone, u.Band)
+	for u.Northing < bottom.Northing-100000.0 {
+		u.Northing += 2000000.0
+	}
+
+	return ell.FromUTM(u)
+}
@@ -0,0 +1,275 @@
+/*
+Copyright (c) 2012, Matthew H. Reilly (kb1vc)
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are
+met:
+
+    Redistributions of source code must retain the above copyright
+    notice, this list of conditions and the following disclaimer.
+    Redistributions in binary form must reproduce the above copyright
+    notice, this list of conditions and the following disclaimer in
+    the documentation and/or other materials provided with the
+    distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+"AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+// Parsing and formatting of human-entered locations.
+package location
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	gridRE = regexp.MustCompile(`^[A-Ra-r]{2}([0-9]{2}([A-Xa-x]{2}([0-9]{2}([A-Xa-x]{2}([0-9]{2})?)?)?)?)?$`)
+	utmRE  = regexp.MustCompile(`^(\d{1,2})\s*([C-HJ-NP-Xc-hj-np-x])\s+(\d{5,}(?:\.\d*)?)\s*(?:m?E)?\s+(\d+(?:\.\d*)?)\s*(?:m?N)?$`)
+	mgrsRE = regexp.MustCompile(`^\d{1,2}\s*[C-HJ-NP-Xc-hj-np-x]\s*[A-HJ-NP-Za-hj-np-z][A-HJ-NP-Va-hj-np-v](\s*\d+)*$`)
+)
+
+// Parse a human-entered location. Parse accepts
+//
+//	Maidenhead locators               FN42il, FN42il10lh
+//	decimal degrees (lat then lon)    42.358,-71.05   42.358 -71.05
+//	degrees, minutes and seconds      42 21 30N 71 03 W   N42°21'30" W71°03'
+//	UTM (with the latitude band)      19T 330000 4690000
+//	MGRS                              19TCG3000090000   19T CG 30000 90000
+//
+// Decimal degrees and DMS may mix and may carry N/S/E/W hemisphere
+// letters, in which case the latitude and longitude may come in
+// either order.
+func Parse(s string) (LatLon, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return LatLon{}, errors.New("Missing location")
+	}
+
+	switch {
+	case gridRE.MatchString(s):
+		return FromGrid(s)
+	case utmRE.MatchString(s):
+		m := utmRE.FindStringSubmatch(s)
+		var u UTM
+		u.Zone, _ = strconv.Atoi(m[1])
+		u.Band = strings.ToUpper(m[2])[0]
+		u.Easting, _ = strconv.ParseFloat(m[3], 64)
+		u.Northing, _ = strconv.ParseFloat(m[4], 64)
+		return u.LatLon()
+	case mgrsRE.MatchString(s):
+		return FromMGRS(s)
+	}
+
+	return parseDegrees(s)
+}
+
+// one coordinate of a decimal or DMS location
+type degreeGroup struct {
+	hemi byte // N, S, E, W or 0
+	nums []float64
+}
+
+// Split s into numbers and hemisphere letters; the degree, minute
+// and second marks and commas all separate fields.
+func degreeTokens(s string) ([]string, error) {
+	var ret []string
+	cur := ""
+	flush := func() {
+		if cur != "" {
+			ret = append(ret, cur)
+			cur = ""
+		}
+	}
+	for _, r := range s {
+		switch {
+		case (r >= '0' && r <= '9') || (r == '.'):
+			cur += string(r)
+		case (r == '-') || (r == '+'):
+			flush()
+			cur = string(r)
+		case strings.ContainsRune("NSEWnsew", r):
+			flush()
+			ret = append(ret, strings.ToUpper(string(r)))
+		case r == ',':
+			flush()
+			ret = append(ret, ",")
+		case unicode.IsSpace(r) || strings.ContainsRune("°º'\"′″:", r):
+			flush()
+		default:
+			return nil, errors.New(fmt.Sprintf("Unexpected character %q in location %q", r, s))
+		}
+	}
+	flush()
+	return ret, nil
+}
+
+// Parse decimal degrees or degrees, minutes and seconds.
+func parseDegrees(s string) (LatLon, error) {
+	toks, err := degreeTokens(s)
+	if err != nil {
+		return LatLon{}, err
+	}
+	bad := func(why string) (LatLon, error) {
+		return LatLon{}, errors.New(fmt.Sprintf("Can't parse location %q: %s", s, why))
+	}
+
+	isHemi := func(t string) bool { return (len(t) == 1) && strings.Contains("NSEW", t) }
+	prefix := (len(toks) > 0) && isHemi(toks[0])
+	hashemi := false
+
+	// gather the tokens into two groups
+	var groups []degreeGroup
+	cur := degreeGroup{}
+	for i, t := range toks {
+		switch {
+		case t == ",":
+			if len(cur.nums) > 0 {
+				groups = append(groups, cur)
+				cur = degreeGroup{}
+			}
+		case isHemi(t):
+			hashemi = true
+			if prefix {
+				if (i > 0) && (len(cur.nums) > 0) {
+					groups = append(groups, cur)
+				}
+				cur = degreeGroup{hemi: t[0]}
+			} else {
+				cur.hemi = t[0]
+				groups = append(groups, cur)
+				cur = degreeGroup{}
+			}
+		default:
+			v, err := strconv.ParseFloat(t, 64)
+			if err != nil {
+				return bad(fmt.Sprintf("bad number %q", t))
+			}
+			cur.nums = append(cur.nums, v)
+		}
+	}
+	if (len(cur.nums) > 0) || (cur.hemi != 0) {
+		groups = append(groups, cur)
+	}
+
+	// two bare numbers are decimal lat and lon
+	if (len(groups) == 1) && !hashemi && (len(groups[0].nums) == 2) {
+		g := groups[0]
+		groups = []degreeGroup{{nums: g.nums[:1]}, {nums: g.nums[1:]}}
+	}
+	if len(groups) != 2 {
+		return bad("expected a latitude and a longitude")
+	}
+
+	var ret LatLon
+	var have [2]bool
+	for i, g := range groups {
+		v, err := dmsValue(g.nums)
+		if err != nil {
+			return bad(err.Error())
+		}
+		islon := i == 1
+		switch g.hemi {
+		case 'S':
+			v = -v
+		case 'E':
+			islon = true
+		case 'W':
+			v, islon = -v, true
+		case 'N':
+			islon = false
+		}
+		if islon {
+			ret.Lon = v
+			have[1] = true
+		} else {
+			ret.Lat = v
+			have[0] = true
+		}
+	}
+	if !have[0] || !have[1] {
+		return bad("expected one latitude and one longitude")
+	}
+	if (ret.Lat < -90.0) || (ret.Lat > 90.0) || (ret.Lon < -180.0) || (ret.Lon > 180.0) {
+		return bad("out of range")
+	}
+	return ret, nil
+}
+
+// combine degrees, minutes and seconds
+func dmsValue(nums []float64) (float64, error) {
+	if (len(nums) < 1) || (len(nums) > 3) {
+		return 0.0, errors.New("expected degrees, minutes and seconds")
+	}
+	sign := 1.0
+	if math.Signbit(nums[0]) {
+		sign = -1.0
+	}
+	v := math.Abs(nums[0])
+	scale := 1.0
+	for _, n := range nums[1:] {
+		if (n < 0.0) || (n >= 60.0) {
+			return 0.0, errors.New(fmt.Sprintf("minutes and seconds must be in [0, 60), got %g", n))
+		}
+		scale *= 60.0
+		v += n / scale
+	}
+	return sign * v, nil
+}
+
+// Format ll as decimal degrees, "42.358000,-71.050000", with
+// places digits after the decimal point.
+func FormatDecimal(ll LatLon, places int) string {
+	return fmt.Sprintf("%.*f,%.*f", places, ll.Lat, places, ll.Lon)
+}
+
+// Format ll as degrees, minutes and seconds, "42°21'30"N 71°03'00"W",
+// with places digits after the decimal point in the seconds.
+func FormatDMS(ll LatLon, places int) string {
+	return formatDMS(ll.Lat, "NS", places) + " " + formatDMS(ll.Lon, "EW", places)
+}
+
+func formatDMS(v float64, hemi string, places int) string {
+	h := hemi[0]
+	if v < 0.0 {
+		h = hemi[1]
+	}
+	// round once, in units of the last place of the seconds
+	unit := math.Pow(10.0, float64(places))
+	t := math.Round(math.Abs(v) * 3600.0 * unit)
+	sec := math.Mod(t, 60.0*unit) / unit
+	t = math.Floor(t / (60.0 * unit))
+	min := math.Mod(t, 60.0)
+	deg := math.Floor(t / 60.0)
+
+	w := 2
+	if places > 0 {
+		w = places + 3
+	}
+	return fmt.Sprintf("%.0f°%02.0f'%0*.*f\"%c", deg, min, w, places, sec, h)
+}
+
+// Format ll in UTM coordinates on the DefaultEllipsoid, "19T 330000 4690000".
+func FormatUTM(ll LatLon) (string, error) {
+	u, err := ll.UTM()
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,152 @@
+/*
+Copyright (c) 2012, Matthew H. Reilly (kb1vc)
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are
+met:
+
+    Redistributions of source code must retain the above copyright
+    notice, this list of conditions and the following disclaimer.
+    Redistributions in binary form must reproduce the above copyright
+    notice, this list of conditions and the following disclaimer in
+    the documentation and/or other materials provided with the
+    distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+"AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package location
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Which way round the earth a path runs.
+type PathChoice int
+
+const (
+	ShortPath PathChoice = iota // the shortest geodesic between the endpoints
+	LongPath                    // the other way round, through the antipode of the short path's midpoint
+)
+
+// A point along a path, dist km from its start.
+type Waypoint struct {
+	Dist float64
+	Pos  LatLon
+}
+
+// A geodesic path from one LatLon to another, for sampling.
+// The long path is built from two geodesic legs that meet at the
+// antipode of the short path's midpoint.
+type Path struct {
+	From  LatLon
+	To    LatLon
+	Which PathChoice
+	Dist  float64 // length of the path in km
+
+	ell  Ellipsoid
+	legs []Geodesic
+}
+
+// Create the path from fr to to on the DefaultEllipsoid.
+func NewPath(fr, to LatLon, which PathChoice) Path {
+	return DefaultEllipsoid.Path(fr, to, which)
+}
+
+// Create the path from fr to to on ellipsoid ell.
+func (ell Ellipsoid) Path(fr, to LatLon, which PathChoice) Path {
+	ret := Path{From: fr, To: to, Which: which, ell: ell}
+
+	sp := ell.Inverse(fr, to)
+	if which == LongPath {
+		mid := ell.Direct(fr, sp.Azimuth1, sp.Dist/2.0).To
+		anti := LatLon{-mid.Lat, mid.Lon + 180.0}
+		if anti.Lon > 180.0 {
+			anti.Lon -= 360.0
+		}
+		ret.legs = []Geodesic{ell.Inverse(fr, anti), ell.Inverse(anti, to)}
+	} else {
+		ret.legs = []Geodesic{sp}
+	}
+
+	for _, l := range ret.legs {
+		ret.Dist += l.Dist
+	}
+
+	return ret
+}
+
+// Return the bearing at the start of the path in degrees.
+func (p Path) Bearing() float64 {
+	return p.legs[0].Azimuth1
+}
+
+// Return the point dist km along the path.  Distances
+// beyond either end are clamped to the endpoints.
+func (p Path) At(dist float64) LatLon {
+	if dist <= 0.0 {
+		return p.From
+	}
+	if dist >= p.Dist {
+		return p.To
+	}
+	for _, l := range p.legs {
+		if dist <= l.Dist {
+			return p.ell.Direct(l.From, l.Azimuth1, dist).To
+		}
+		dist -= l.Dist
+	}
+	return p.To
+}
+
+// Return n evenly spaced points along the path, the first
+// at From and the last at To.
+func (p Path) Points(n int) ([]Waypoint, error) {
+	if n < 2 {
+		return nil, errors.New(fmt.Sprintf("A path needs at least 2 points, got %d", n))
+	}
+
+	ret := make([]Waypoint, n)
+	step := p.Dist / float64(n-1)
+	for i := range ret {
+		d := step * float64(i)
+		if i == n-1 {
+			d = p.Dist
+		}
+		ret[i] = Waypoint{Dist: d, Pos: p.At(d)}
+	}
+	return ret, nil
+}
+
+// Return points every spacing km along the path, starting at From.
+// The last point is at To, and may be closer than spacing to the
+// one before it.
+func (p Path) Every(spacing float64) ([]Waypoint, error) {
+	if !(spacing > 0.0) {
+		return nil, errors.New(fmt.Sprintf("Path point spacing must be positive, got %f", spacing))
+	}
+
+	n := int(math.Ceil(p.Dist/spacing-1.0e-9)) + 1
+	if n < 2 {
+		n = 2
+	}
+	ret := make([]Waypoint, n)
+	for i := range ret {
+		d := math.Min(spacing*float64(i), p.Dist)
+		ret[i] = Waypoint{Dist: d, Pos: p.At(d)}
+	}
+	return ret, nil
+}
@@ -0,0 +1,250 @@
+/*
+Copyright (c) 2012, Matthew H. Reilly (kb1vc)
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are
+met:
+
+    Redistributions of source code must retain the above copyright
+    notice, this list of conditions and the following disclaimer.
+    Redistributions in binary form must reproduce the above copyright
+    notice, this list of conditions and the following disclaimer in
+    the documentation and/or other materials provided with the
+    distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+"AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+// Universal Transverse Mercator coordinates, using the Krüger series
+// (to sixth order in n) as given by C. F. F. Karney, "Transverse
+// Mercator with an accuracy of a few nanometers", J. Geodesy 85,
+// 475-485 (2011).
+package location
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+const (
+	utmK0            = 0.9996
+	utmFalseEasting  = 500000.0
+	utmFalseNorthing = 10000000.0 // for the southern hemisphere
+	utmMinLat        = -80.0
+	utmMaxLat        = 84.0
+)
+
+// The UTM latitude bands, 8 degrees each from 80S, except for X
+// which covers 72N to 84N.
+const utmBands = "CDEFGHJKLMNPQRSTUVWXX"
+
+// A point in UTM coordinates.
+type UTM struct {
+	Zone     int     // 1..60
+	Band     byte    // latitude band letter, C..X; N and above are in the northern hemisphere
+	Easting  float64 // meters, with a 500 km false easting
+	Northing float64 // meters from the equator; southern points have a 10000 km false northing
+}
+
+// Return true if u is in the northern hemisphere.
+func (u UTM) North() bool {
+	return u.Band >= 'N'
+}
+
+// Format u as "19T 330000 4690000".
+func (u UTM) String() string {
+	return fmt.Sprintf("%d%c %.0f %.0f", u.Zone, u.Band, u.Easting, u.Northing)
+}
+
+// Return the UTM coordinates of ll on the DefaultEllipsoid.
+func (ll LatLon) UTM() (UTM, error) {
+	return DefaultEllipsoid.ToUTM(ll)
+}
+
+// Return the location of u on the DefaultEllipsoid.
+func (u UTM) LatLon() (LatLon, error) {
+	return DefaultEllipsoid.FromUTM(u)
+}
+
+// Return the UTM zone and latitude band for ll, including the
+// exceptions for southern Norway and Svalbard.
+func utmZone(ll LatLon) (int, byte, error) {
+	if (ll.Lat < utmMinLat) || (ll.Lat > utmMaxLat) || (ll.Lon < -180.0) || (ll.Lon > 180.0) {
+		return 0, 0, errors.New(fmt.Sprintf("Location %f lat %f lon is outside the UTM system", ll.Lat, ll.Lon))
+	}
+
+	zone := int(math.Floor((ll.Lon+180.0)/6.0)) + 1
+	if zone > 60 {
+		zone = 60
+	}
+	bi := int(math.Floor((ll.Lat - utmMinLat) / 8.0))
+	if bi >= len(utmBands) {
+		bi = len(utmBands) - 1
+	}
+	band := utmBands[bi]
+
+	if (band == 'V') && (zone == 31) && (ll.Lon >= 3.0) {
+		zone = 32
+	}
+	if (band == 'X') && (ll.Lon >= 0.0) && (ll.Lon < 42.0) {
+		switch {
+		case ll.Lon < 9.0:
+			zone = 31
+		case ll.Lon < 21.0:
+			zone = 33
+		case ll.Lon < 33.0:
+			zone = 35
+		default:
+			zone = 37
+		}
+	}
+	return zone, band, nil
+}
+
+// the central meridian of a UTM zone
+func utmMeridian(zone int) float64 {
+	return float64(zone-1)*6.0 - 180.0 + 3.0
+}
+
+// Krüger series constants for an ellipsoid
+type kruger struct {
+	e     float64
+	ka    float64 // k0 times the rectifying radius
+	alpha [7]float64
+	beta  [7]float64
+}
+
+func newKruger(ell Ellipsoid) kruger {
+	var k kruger
+	f := ell.F
+	n := f / (2.0 - f)
+	n2 := n * n
+	n3 := n * n2
+	n4 := n * n3
+	n5 := n * n4
+	n6 := n * n5
+
+	k.e = math.Sqrt(f * (2.0 - f))
+	k.ka = utmK0 * ell.A / (1.0 + n) * (1.0 + n2/4.0 + n4/64.0 + n6/256.0)
+
+	k.alpha = [7]float64{0.0,
+		n/2.0 - 2.0*n2/3.0 + 5.0*n3/16.0 + 41.0*n4/180.0 - 127.0*n5/288.0 + 7891.0*n6/37800.0,
+		13.0*n2/48.0 - 3.0*n3/5.0 + 557.0*n4/1440.0 + 281.0*n5/630.0 - 1983433.0*n6/1935360.0,
+		61.0*n3/240.0 - 103.0*n4/140.0 + 15061.0*n5/26880.0 + 167603.0*n6/181440.0,
+		49561.0*n4/161280.0 - 179.0*n5/168.0 + 6601661.0*n6/7257600.0,
+		34729.0*n5/80640.0 - 3418889.0*n6/1995840.0,
+		212378941.0 * n6 / 319334400.0,
+	}
+	k.beta = [7]float64{0.0,
+		n/2.0 - 2.0*n2/3.0 + 37.0*n3/96.0 - n4/360.0 - 81.0*n5/512.0 + 96199.0*n6/604800.0,
+		n2/48.0 + n3/15.0 - 437.0*n4/1440.0 + 46.0*n5/105.0 - 1118711.0*n6/3870720.0,
+		17.0*n3/480.0 - 37.0*n4/840.0 - 209.0*n5/4480.0 + 5569.0*n6/90720.0,
+		4397.0*n4/161280.0 - 11.0*n5/504.0 - 830251.0*n6/7257600.0,
+		4583.0*n5/161280.0 - 108847.0*n6/3991680.0,
+		20648693.0 * n6 / 638668800.0,
+	}
+	return k
+}
+
+// conformal latitude tangent from the geographic latitude tangent
+func (k kruger) tauPrime(tau float64) float64 {
+	sigma := math.Sinh(k.e * math.Atanh(k.e*tau/math.Sqrt(1.0+tau*tau)))
+	return tau*math.Sqrt(1.0+sigma*sigma) - sigma*math.Sqrt(1.0+tau*tau)
+}
+
+// Return the UTM coordinates of ll on ellipsoid ell.
+func (ell Ellipsoid) ToUTM(ll LatLon) (UTM, error) {
+	zone, band, err := utmZone(ll)
+	if err != nil {
+		return UTM{}, err
+	}
+	return ell.toUTMZone(ll, zone, band), nil
+}
+
+// project ll into a given zone
+func (ell Ellipsoid) toUTMZone(ll LatLon, zone int, band byte) UTM {
+	k := newKruger(ell)
+
+	phi := ll.Lat * deg2rad
+	lam := math.Remainder(ll.Lon-utmMeridian(zone), 360.0) * deg2rad
+
+	coslam, sinlam := math.Cos(lam), math.Sin(lam)
+	taup := k.tauPrime(math.Tan(phi))
+	xip := math.Atan2(taup, coslam)
+	etap := math.Asinh(sinlam / math.Sqrt(taup*taup+coslam*coslam))
+
+	xi, eta := xip, etap
+	for j := 1; j <= 6; j++ {
+		fj := 2.0 * float64(j)
+		xi += k.alpha[j] * math.Sin(fj*xip) * math.Cosh(fj*etap)
+		eta += k.alpha[j] * math.Cos(fj*xip) * math.Sinh(fj*etap)
+	}
+
+	ret := UTM{Zone: zone, Band: band}
+	ret.Easting = k.ka*eta + utmFalseEasting
+	ret.Northing = k.ka * xi
+	if !ret.North() {
+		ret.Northing += utmFalseNorthing
+	}
+	return ret
+}
+
+// Return the location of u on ellipsoid ell.
+func (ell Ellipsoid) FromUTM(u UTM) (LatLon, error) {
+	if (u.Zone < 1) || (u.Zone > 60) {
+		return LatLon{}, errors.New(fmt.Sprintf("Bad UTM zone %d: must be 1 to 60", u.Zone))
+	}
+	if (u.Band < utmBands[0]) || (u.Band > 'X') || (u.Band == 'I') || (u.Band == 'O') {
+		return LatLon{}, errors.New(fmt.Sprintf("Bad UTM latitude band %c: must be C to X, without I or O", u.Band))
+	}
+
+	k := newKruger(ell)
+
+	y := u.Northing
+	if !u.North() {
+		y -= utmFalseNorthing
+	}
+	eta := (u.Easting - utmFalseEasting) / k.ka
+	xi := y / k.ka
+
+	xip, etap := xi, eta
+	for j := 1; j <= 6; j++ {
+		fj := 2.0 * float64(j)
+		xip -= k.beta[j] * math.Sin(fj*xi) * math.Cosh(fj*eta)
+		etap -= k.beta[j] * math.Cos(fj*xi) * math.Sinh(fj*eta)
+	}
+
+	sinhetap := math.Sinh(etap)
+	sinxip, cosxip := math.Sin(xip), math.Cos(xip)
+	taup := sinxip / math.Sqrt(sinhetap*sinhetap+cosxip*cosxip)
+
+	// Newton's method for the geographic latitude tangent
+	e2 := k.e * k.e
+	tau := taup
+	for i := 0; i < 10; i++ {
+		taui := k.tauPrime(tau)
+		dtau := (taup - taui) / math.Sqrt(1.0+taui*taui) *
+			(1.0 + (1.0-e2)*tau*tau) / ((1.0 - e2) * math.Sqrt(1.0+tau*tau))
+		tau += dtau
+		if math.Abs(dtau) < 1.0e-12 {
+			break
+		}
+	}
+
+	var ret LatLon
+	ret.Lat = math.Atan(tau) / deg2rad
+	ret.Lon = angNormalize(math.Atan2(sinhetap, cosxip)/deg2rad + utmMeridian(u.Zone))
+	return ret, nil
+}
@@ -0,0 +1,140 @@
+// A bounded cache of decoded map tiles.
+package nedmap
+
+import (
+	"container/list"
+	"sync"
+)
+
+// The default memory budget for a TileCache: about twenty
+// decoded 3612x3612 NED tiles.
+const DefaultCacheBudget int64 = 1 << 30
+
+// A TileCache holds decoded map tiles up to a memory budget,
+// discarding the least recently used tiles when the budget is exceeded.
+//
+// Tiles are loaded on demand by the cache's load function. If several
+// goroutines ask for the same tile while it is being loaded, the tile
+// is only decoded once and all of them get the result.
+type TileCache struct {
+	budget int64
+	load   func(name string) (*MapData, error)
+
+	mutex   sync.Mutex
+	entries map[string]*cacheEntry
+	lru     *list.List // loaded entries, most recently used at the front
+	size    int64
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+type cacheEntry struct {
+	name  string
+	m     *MapData
+	err   error
+	size  int64
+	ready chan struct{} // closed when the load is complete
+	elem  *list.Element
+}
+
+// Counters and memory use for a TileCache.
+type CacheStats struct {
+	Hits      uint64 // requests satisfied by a tile that was already loaded (or loading)
+	Misses    uint64 // requests that had to load a tile
+	Evictions uint64 // tiles discarded to stay within the budget
+	Tiles     int    // number of tiles in the cache now
+	Bytes     int64  // estimated size of the tiles in the cache now
+	Budget    int64  // the memory budget for the cache
+}
+
+// Create a tile cache that holds at most budget bytes of decoded tiles
+// (or DefaultCacheBudget, if budget is not positive) and uses load to
+// read tiles that are not in the cache.
+func NewTileCache(budget int64, load func(name string) (*MapData, error)) *TileCache {
+	if budget <= 0 {
+		budget = DefaultCacheBudget
+	}
+	return &TileCache{budget: budget, load: load,
+		entries: make(map[string]*cacheEntry), lru: list.New()}
+}
+
+// Estimate the memory used by a decoded map. A map that reads its rows
+// on demand is counted as if they were all loaded.
+func mapSize(m *MapData) int64 {
+	// 24 bytes of slice header per row plus the elevations themselves
+	return int64(m.MD.rows) * (24 + 4*int64(m.MD.cols))
+}
+
+// Return the named tile, loading it if it is not in the cache.
+func (c *TileCache) Get(name string) (*MapData, error) {
+	c.mutex.Lock()
+	if e, ok := c.entries[name]; ok {
+		c.hits++
+		if e.elem != nil {
+			c.lru.MoveToFront(e.elem)
+		}
+		c.mutex.Unlock()
+		<-e.ready
+		return e.m, e.err
+	}
+
+	c.misses++
+	e := &cacheEntry{name: name, ready: make(chan struct{})}
+	c.entries[name] = e
+	c.mutex.Unlock()
+
+	m, err := c.load(name)
+
+	c.mutex.Lock()
+	e.m, e.err = m, err
+	if err != nil {
+		// don't remember failures -- the next request will try again.
+		delete(c.entries, name)
+	} else {
+		e.size = mapSize(m)
+		e.elem = c.lru.PushFront(e)
+		c.size += e.size
+		c.evict()
+	}
+	close(e.ready)
+	c.mutex.Unlock()
+
+	return m, err
+}
+
+// Drop the named tile from the cache if the cache still holds m for
+// it, so that the next Get loads it again. This is for a tile whose
+// file has changed since it was loaded.
+func (c *TileCache) Forget(name string, m *MapData) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	e, ok := c.entries[name]
+	if !ok || (e.elem == nil) || (e.m != m) {
+		return
+	}
+	c.lru.Remove(e.elem)
+	delete(c.entries, name)
+	c.size -= e.size
+}
+
+// Discard least recently used tiles until the cache fits in its budget.
+// The most recently used tile is always kept, even if it alone exceeds
+// the budget. Called with the mutex held.
+func (c *TileCache) evict() {
+	for (c.size > c.budget) && (c.lru.Len() > 1) {
+		e := c.lru.Remove(c.lru.Back()).(*cacheEntry)
+		delete(c.entries, e.name)
+		c.size -= e.size
+		c.evictions++
+	}
+}
+
+// Report the cache's counters and memory use.
+func (c *TileCache) Stats() CacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return CacheStats{Hits: c.hits, Misses: c.misses, Evictions: c.evictions,
+		Tiles: c.lru.Len(), Bytes: c.size, Budget: c.budget}
+}
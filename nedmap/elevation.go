@@ -0,0 +1,140 @@
+// Elevation lookup by location within a single map tile.
+package nedmap
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/kb1vc/radiopath/location"
+)
+
+// How to estimate the elevation at a point between the centers
+// of the elevation cells.
+type Interpolation int
+
+const (
+	Nearest  Interpolation = iota // the elevation of the cell containing the point
+	Bilinear                      // linear blend of the four nearest cell centers
+	Bicubic                       // cubic convolution over the sixteen nearest cell centers
+)
+
+// Find the fractional row and column of ll within the map.
+//
+// NED grids are "pixel is area": the bounding box in the metadata
+// is the outer edge of the outermost cells, so the cell in row r and
+// column c covers the latitude range
+// [ur.Lat - (r+1) * dlat, ur.Lat - r * dlat) and similarly for longitude.
+// Row 0 is the northern edge of the map.
+//
+// The returned row and column are measured in cells from the
+// edge of the map, so the center of cell (r, c) is at (r + 0.5, c + 0.5).
+func (m *MapData) gridPos(ll location.LatLon) (float64, float64, error) {
+	md := &m.MD
+	if (ll.Lat < md.ll.Lat) || (ll.Lat > md.ur.Lat) ||
+		(ll.Lon < md.ll.Lon) || (ll.Lon > md.ur.Lon) {
+		return 0, 0, errors.New(fmt.Sprintf("Location %f lat %f lon is outside the map [%f %f] to [%f %f]",
+			ll.Lat, ll.Lon, md.ll.Lat, md.ll.Lon, md.ur.Lat, md.ur.Lon))
+	}
+
+	dlat := (md.ur.Lat - md.ll.Lat) / float64(md.rows)
+	dlon := (md.ur.Lon - md.ll.Lon) / float64(md.cols)
+
+	return (md.ur.Lat - ll.Lat) / dlat, (ll.Lon - md.ll.Lon) / dlon, nil
+}
+
+// Return the elevation at row r, column c, clamping the indices
+// to the edges of the map.
+func (m *MapData) cell(r, c int) float64 {
+	if r < 0 {
+		r = 0
+	} else if r >= m.MD.rows {
+		r = m.MD.rows - 1
+	}
+	if c < 0 {
+		c = 0
+	} else if c >= m.MD.cols {
+		c = m.MD.cols - 1
+	}
+	return float64(m.Elevation[r][c])
+}
+
+// Return the elevation (in meters) of the cell that contains ll.
+func (m *MapData) ElevationAt(ll location.LatLon) (float64, error) {
+	return m.ElevationInterp(ll, Nearest)
+}
+
+// Return the elevation (in meters) at ll, interpolated between
+// the neighboring cell centers as selected by "how".
+//
+// NED tiles overlap their neighbors by a few cells on every side, and
+// a TileStore always picks the tile whose interior contains the point,
+// so the interpolation neighborhood is real data even at a tile edge.
+// Only at the outer edge of a map do we run out of cells; there the
+// edge cells are repeated.
+func (m *MapData) ElevationInterp(ll location.LatLon, how Interpolation) (float64, error) {
+	fr, fc, err := m.gridPos(ll)
+	if err != nil {
+		return 0.0, err
+	}
+	// the rows that any of the methods might need
+	r0 := int(math.Floor(fr - 0.5))
+	if err := m.LoadRows(r0-1, r0+3); err != nil {
+		return 0.0, err
+	}
+
+	switch how {
+	case Nearest:
+		return m.cell(int(math.Floor(fr)), int(math.Floor(fc))), nil
+	case Bilinear:
+		return m.bilinear(fr-0.5, fc-0.5), nil
+	case Bicubic:
+		return m.bicubic(fr-0.5, fc-0.5), nil
+	}
+
+	return 0.0, errors.New(fmt.Sprintf("Unknown interpolation method %d", how))
+}
+
+// Interpolate linearly between the four cell centers around (r, c),
+// where r and c are in units of cells with cell centers at integer values.
+func (m *MapData) bilinear(r, c float64) float64 {
+	r0, c0 := math.Floor(r), math.Floor(c)
+	tr, tc := r-r0, c-c0
+	ir, ic := int(r0), int(c0)
+
+	top := (1.0-tc)*m.cell(ir, ic) + tc*m.cell(ir, ic+1)
+	bot := (1.0-tc)*m.cell(ir+1, ic) + tc*m.cell(ir+1, ic+1)
+	return (1.0-tr)*top + tr*bot
+}
+
+// Cubic convolution (Keys, a = -0.5) weight for a sample at offset t
+// from the interpolation point.
+func cubicWeight(t float64) float64 {
+	t = math.Abs(t)
+	switch {
+	case t < 1.0:
+		return (1.5*t-2.5)*t*t + 1.0
+	case t < 2.0:
+		return ((-0.5*t+2.5)*t-4.0)*t + 2.0
+	}
+	return 0.0
+}
+
+// Interpolate over the sixteen cell centers around (r, c), where r and c
+// are in units of cells with cell centers at integer values.
+func (m *MapData) bicubic(r, c float64) float64 {
+	r0, c0 := math.Floor(r), math.Floor(c)
+	tr, tc := r-r0, c-c0
+	ir, ic := int(r0), int(c0)
+
+	var ret float64
+	for i := -1; i <= 2; i++ {
+		wr := cubicWeight(tr - float64(i))
+		var row float64
+		for j := -1; j <= 2; j++ {
+			row += cubicWeight(tc-float64(j)) * m.cell(ir+i, ic+j)
+		}
+		ret += wr * row
+	}
+	return ret
+}
@@ -0,0 +1,476 @@
+// Indexed (version 2) compressed map files: the rows are stored in
+// separately compressed and checksummed blocks, so that a reader can
+// decode just the rows it needs.
+package nedmap
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The number of rows in each separately compressed block of the
+// indexed map files written by WriteZCompressedMap.
+const DefaultBlockRows = 32
+
+// The fixed part of the header of an indexed map file. The block
+// offsets and checksums follow it.
+type indexedHeader struct {
+	Esc                        byte
+	SOF                        int16
+	Version                    int16
+	LLLat, LLLon, URLat, URLon float32
+	Rows, Cols, BlockRows      int16
+}
+
+// Where the row blocks of an indexed map file are. The size and
+// modification time of the file when it was opened tell whether it has
+// been replaced since: the offsets are no good for a new file.
+type tileIndex struct {
+	name      string
+	size      int64
+	modTime   time.Time
+	blockRows int
+	offsets   []int64  // file offset of each block, then of the end of the last one
+	crcs      []uint32 // CRC-32 of each uncompressed block, nil for version 0x0200 files
+}
+
+// The state of a row block of a map read on demand. Once a block has
+// been read it stays loaded; a read that fails is tried again the next
+// time the block's rows are wanted.
+type mapBlock struct {
+	mutex  sync.Mutex
+	loaded uint32 // set (atomically) once the rows are in Elevation
+}
+
+// Write the map as an indexed (version 2, 0x0202) map file with
+// blockRows rows in each block.
+func (m *MapData) WriteIndexedMap(w io.Writer, blockRows int) error {
+	return m.writeIndexedMap(w, blockRows, currentFileVersion)
+}
+
+// Write the map as an indexed map file of the given version (0x0200 to
+// 0x0202), leaving out whatever that version lacks.
+func (m *MapData) writeIndexedMap(w io.Writer, blockRows int, version int16) error {
+	rows, cols := m.MD.rows, m.MD.cols
+	if (rows <= 0) || (rows > 0x7fff) || (cols <= 0) || (cols > 0x7fff) {
+		return errors.New(fmt.Sprintf("Can't write a %d x %d map", rows, cols))
+	}
+	if (blockRows <= 0) || (blockRows > 0x7fff) {
+		return errors.New(fmt.Sprintf("Rows per block must be between 1 and %d, got %d", 0x7fff, blockRows))
+	}
+	if err := m.LoadRows(0, rows); err != nil {
+		return err
+	}
+
+	nb := (rows + blockRows - 1) / blockRows
+	blocks := make([][]byte, nb)
+	crcs := make([]uint32, nb)
+	for b := range blocks {
+		var buf bytes.Buffer
+		fw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		if err != nil {
+			return err
+		}
+		crc := crc32.NewIEEE()
+		ns := nybbleOutStream{wr: io.MultiWriter(fw, crc)}
+		last := (b + 1) * blockRows
+		if last > rows {
+			last = rows
+		}
+		ns.writeRows(m.Elevation[b*blockRows : last])
+		ns.terminateOut()
+		if err := fw.Close(); err != nil {
+			return err
+		}
+		blocks[b] = buf.Bytes()
+		crcs[b] = crc.Sum32()
+	}
+
+	var meta []byte
+	if version >= describedFileVersion {
+		var err error
+		if meta, err = encodeMetadata(&m.MD); err != nil {
+			return err
+		}
+	}
+	hdr := indexedHeader{Esc: escMarker, SOF: startOfFileMarker, Version: version,
+		LLLat: float32(m.MD.ll.Lat), LLLon: float32(m.MD.ll.Lon),
+		URLat: float32(m.MD.ur.Lat), URLon: float32(m.MD.ur.Lon),
+		Rows: int16(rows), Cols: int16(cols), BlockRows: int16(blockRows)}
+	offsets := make([]int64, nb+1)
+	offsets[0] = indexEnd(hdr.Version, int64(len(meta)), nb)
+	for b := range blocks {
+		offsets[b+1] = offsets[b] + int64(len(blocks[b]))
+	}
+
+	// the header is checksummed, and the whole file digested
+	var head bytes.Buffer
+	binary.Write(&head, binary.LittleEndian, hdr)
+	head.Write(meta)
+	binary.Write(&head, binary.LittleEndian, offsets)
+	if version >= checkedFileVersion {
+		binary.Write(&head, binary.LittleEndian, crcs)
+		binary.Write(&head, binary.LittleEndian, crc32.ChecksumIEEE(head.Bytes()))
+	}
+
+	digest := sha256.New()
+	dw := io.MultiWriter(w, digest)
+	if _, err := dw.Write(head.Bytes()); err != nil {
+		return err
+	}
+	for _, blk := range blocks {
+		if _, err := dw.Write(blk); err != nil {
+			return err
+		}
+	}
+	if version < checkedFileVersion {
+		return nil
+	}
+	_, err := w.Write(digest.Sum(nil))
+	return err
+}
+
+// Return the size of the header and index of an indexed file of the
+// given version with metaLen bytes of metadata and nb row blocks: the
+// offset of the first block.
+func indexEnd(version int16, metaLen int64, nb int) int64 {
+	ret := int64(binary.Size(indexedHeader{})) + metaLen + 8*int64(nb+1)
+	if version >= checkedFileVersion {
+		ret += 4*int64(nb) + 4
+	}
+	return ret
+}
+
+// The description of a map, as it is written in the header: the
+// metadata keys and their values.
+func (md *MapInfo) metadata() [][2]string {
+	return [][2]string{{"name", md.name}, {"hdatum", md.hdatum}, {"vdatum", md.vdatum},
+		{"units", md.units}, {"nodata", strconv.FormatFloat(md.noData, 'g', -1, 64)},
+		{"source", md.source}, {"date", md.date}}
+}
+
+// Encode the description of a map for the header: a uint16 count of
+// entries, then a key and a value string for each, where each string is
+// a uint16 length and that many bytes.
+func encodeMetadata(md *MapInfo) ([]byte, error) {
+	var buf bytes.Buffer
+	kv := md.metadata()
+	binary.Write(&buf, binary.LittleEndian, uint16(len(kv)))
+	for _, e := range kv {
+		for _, str := range e {
+			if len(str) > 0xffff {
+				return nil, errors.New(fmt.Sprintf("Map %s is too long to write (%d bytes)", e[0], len(str)))
+			}
+			binary.Write(&buf, binary.LittleEndian, uint16(len(str)))
+			buf.WriteString(str)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// Read the description of a map from the header into md and return its
+// size in bytes. Keys this reader doesn't know are skipped.
+func decodeMetadata(r io.Reader, md *MapInfo) (int64, error) {
+	size := int64(0)
+	readString := func() (string, error) {
+		var n uint16
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return "", err
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", io.ErrUnexpectedEOF
+		}
+		size += 2 + int64(n)
+		return string(buf), nil
+	}
+
+	var count uint16
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return size, err
+	}
+	size += 2
+	for i := 0; i < int(count); i++ {
+		key, err := readString()
+		if err != nil {
+			return size, err
+		}
+		val, err := readString()
+		if err != nil {
+			return size, err
+		}
+		switch key {
+		case "name":
+			md.name = val
+		case "hdatum":
+			md.hdatum = val
+		case "vdatum":
+			md.vdatum = val
+		case "units":
+			md.units = val
+		case "nodata":
+			if v, err := strconv.ParseFloat(val, 64); err == nil {
+				md.noData = v
+			}
+		case "source":
+			md.source = val
+		case "date":
+			md.date = val
+		}
+	}
+	return size, nil
+}
+
+// attach the file name to a format error
+func inFile(err error, fname string) error {
+	if ferr, ok := err.(*FormatError); ok {
+		ferr.File = fname
+	}
+	return err
+}
+
+// the error for a header or index that could not be read
+func headerError(err error, fname string, off int64) error {
+	if (err == io.EOF) || (err == io.ErrUnexpectedEOF) {
+		return &FormatError{File: fname, Err: ErrTruncated, Row: -1, Offset: off}
+	}
+	return &FormatError{File: fname, Err: err, Row: -1, Offset: off}
+}
+
+// Open a compressed map file of any version. The rows of an indexed
+// (version 2) file are read a block at a time as they are needed (see
+// LoadRows); older files are read in full right away. Problems with the
+// contents come back as a *FormatError.
+func OpenMap(fname string) (*MapData, error) {
+	m, _, err := openMap(fname)
+	return m, err
+}
+
+// Open a compressed map file and say what sort of file it is.
+func openMap(fname string) (*MapData, Integrity, error) {
+	var integ Integrity
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, integ, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	magic, err := br.Peek(2)
+	if err != nil {
+		return nil, integ, headerError(err, fname, 0)
+	}
+	if (magic[0] == 0x1f) && (magic[1] == 0x8b) {
+		// a gzipped version 1 file: gzip checks its own CRC
+		integ.Version, integ.Checksummed = streamFileVersion, true
+		m, err := readGzippedMap(br, fname)
+		return m, integ, err
+	}
+
+	// the header is checksummed up to the checksum itself
+	crc := crc32.NewIEEE()
+	hr := io.TeeReader(br, crc)
+
+	var hdr indexedHeader
+	if err := binary.Read(hr, binary.LittleEndian, &hdr); err != nil {
+		return nil, integ, headerError(err, fname, 0)
+	}
+	if hdr.Esc != escMarker {
+		return nil, integ, &FormatError{File: fname, Err: ErrBadMagic, Row: -1, Offset: 0,
+			Detail: fmt.Sprintf("expected escape %02x, got %02x", escMarker, hdr.Esc)}
+	}
+	if hdr.SOF != startOfFileMarker {
+		return nil, integ, &FormatError{File: fname, Err: ErrBadMagic, Row: -1, Offset: 1,
+			Detail: fmt.Sprintf("expected start of file marker %04x, got %04x", startOfFileMarker, hdr.SOF)}
+	}
+	integ.Version = hdr.Version
+	switch hdr.Version {
+	case streamFileVersion:
+		// an uncompressed version 1 file
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, integ, err
+		}
+		m, err := ReadCompressedMap(bufio.NewReader(f))
+		return m, integ, inFile(err, fname)
+	case indexedFileVersion, checkedFileVersion, describedFileVersion:
+		integ.Checksummed = hdr.Version >= checkedFileVersion
+	default:
+		return nil, integ, &FormatError{File: fname, Err: ErrVersion, Row: -1, Offset: 3,
+			Detail: fmt.Sprintf("got %04x, expected %04x or %04x to %04x", hdr.Version,
+				streamFileVersion, indexedFileVersion, describedFileVersion)}
+	}
+
+	rows, cols, blockRows := int(hdr.Rows), int(hdr.Cols), int(hdr.BlockRows)
+	hsize := int64(binary.Size(hdr))
+	if (rows <= 0) || (cols <= 0) || (blockRows <= 0) {
+		return nil, integ, &FormatError{File: fname, Err: ErrBadMagic, Row: -1, Offset: hsize - 6,
+			Detail: fmt.Sprintf("impossible map size %d x %d in blocks of %d rows", rows, cols, blockRows)}
+	}
+
+	m := &MapData{}
+	m.MD.ll.Lat, m.MD.ll.Lon = float64(hdr.LLLat), float64(hdr.LLLon)
+	m.MD.ur.Lat, m.MD.ur.Lon = float64(hdr.URLat), float64(hdr.URLon)
+	m.MD.rows, m.MD.cols = rows, cols
+	m.MD.noData = DefaultNoData
+	metaLen := int64(0)
+	if hdr.Version >= describedFileVersion {
+		metaLen, err = decodeMetadata(hr, &m.MD)
+		if err != nil {
+			return nil, integ, headerError(err, fname, hsize+metaLen)
+		}
+		hsize += metaLen
+	}
+	m.MD.setDefaults()
+
+	nb := (rows + blockRows - 1) / blockRows
+	idx := &tileIndex{name: fname, blockRows: blockRows, offsets: make([]int64, nb+1)}
+	st, err := f.Stat()
+	if err != nil {
+		return nil, integ, err
+	}
+	idx.size, idx.modTime = st.Size(), st.ModTime()
+	if err := binary.Read(hr, binary.LittleEndian, idx.offsets); err != nil {
+		return nil, integ, headerError(err, fname, hsize)
+	}
+	if integ.Checksummed {
+		idx.crcs = make([]uint32, nb)
+		if err := binary.Read(hr, binary.LittleEndian, idx.crcs); err != nil {
+			return nil, integ, headerError(err, fname, hsize+8*int64(nb+1))
+		}
+		sum := crc.Sum32()
+		var want uint32
+		if err := binary.Read(br, binary.LittleEndian, &want); err != nil {
+			return nil, integ, headerError(err, fname, indexEnd(hdr.Version, metaLen, nb)-4)
+		}
+		if sum != want {
+			return nil, integ, &FormatError{File: fname, Err: ErrChecksum, Row: -1, Offset: 0,
+				Detail: fmt.Sprintf("header CRC is %08x, expected %08x", sum, want)}
+		}
+	}
+
+	prev := indexEnd(hdr.Version, metaLen, nb)
+	for b, off := range idx.offsets {
+		if off < prev {
+			return nil, integ, &FormatError{File: fname, Err: ErrBadMagic, Row: -1, Offset: hsize + 8*int64(b),
+				Detail: fmt.Sprintf("block %d starts at %d, before the end of the one before it at %d", b, off, prev)}
+		}
+		prev = off
+	}
+	if integ.Checksummed {
+		// leave room for the digest
+		prev += sha256.Size
+	}
+	if prev > st.Size() {
+		return nil, integ, &FormatError{File: fname, Err: ErrTruncated, Row: -1, Offset: st.Size(),
+			Detail: fmt.Sprintf("the file should run to %d", prev)}
+	}
+
+	m.Elevation, m.index, m.blocks = make([][]float32, rows), idx, make([]mapBlock, nb)
+	return m, integ, nil
+}
+
+// Read row block b of the file into rows.
+func (t *tileIndex) readBlock(b int, rows [][]float32, cols int) error {
+	f, err := os.Open(t.name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if (st.Size() != t.size) || !st.ModTime().Equal(t.modTime) {
+		return &FormatError{File: t.name, Err: ErrChanged, Row: b * t.blockRows, Offset: -1,
+			Detail: fmt.Sprintf("it is %d bytes modified %s, was %d bytes modified %s",
+				st.Size(), st.ModTime().Format(time.RFC3339), t.size, t.modTime.Format(time.RFC3339))}
+	}
+
+	off, end := t.offsets[b], t.offsets[b+1]
+	fr := flate.NewReader(io.NewSectionReader(f, off, end-off))
+	defer fr.Close()
+
+	// blocks are small enough to inflate whole, which lets the checksum
+	// be tested before the rows are believed
+	data, err := ioutil.ReadAll(fr)
+	if err != nil {
+		detail := fmt.Sprintf("in row block %d", b)
+		if t.crcs != nil {
+			// the block is all there (the index said so), so it has
+			// been damaged
+			detail = fmt.Sprintf("row block %d: %s", b, err)
+			err = ErrChecksum
+		} else if err == io.ErrUnexpectedEOF {
+			err = ErrTruncated
+		}
+		return &FormatError{File: t.name, Err: err, Row: b * t.blockRows, Offset: -1, Detail: detail}
+	}
+	if t.crcs != nil {
+		if sum := crc32.ChecksumIEEE(data); sum != t.crcs[b] {
+			return &FormatError{File: t.name, Err: ErrChecksum, Row: b * t.blockRows, Offset: -1,
+				Detail: fmt.Sprintf("row block %d CRC is %08x, expected %08x", b, sum, t.crcs[b])}
+		}
+	}
+
+	ns := nybbleInStream{rd: bytes.NewReader(data), odd: false}
+	ns.initIn()
+	return inFile(ns.readRows(rows, b*t.blockRows, cols), t.name)
+}
+
+// Make sure that rows lo up to (but not including) hi are in Elevation,
+// reading them from the map file if need be. Rows outside the map are
+// ignored. Maps that weren't opened with OpenMap are always loaded.
+// It is safe to call LoadRows from several goroutines at once. A block
+// that could not be read is tried again on the next call; if the file
+// was replaced after the map was opened the error is ErrChanged, and the
+// map must be opened again.
+func (m *MapData) LoadRows(lo, hi int) error {
+	if m.index == nil {
+		return nil
+	}
+	if lo < 0 {
+		lo = 0
+	}
+	if hi > m.MD.rows {
+		hi = m.MD.rows
+	}
+
+	br := m.index.blockRows
+	for b := lo / br; b*br < hi; b++ {
+		blk := &m.blocks[b]
+		first, last := b*br, (b+1)*br
+		if last > m.MD.rows {
+			last = m.MD.rows
+		}
+		if atomic.LoadUint32(&blk.loaded) != 0 {
+			continue
+		}
+		blk.mutex.Lock()
+		var err error
+		if blk.loaded == 0 {
+			err = m.index.readBlock(b, m.Elevation[first:last], m.MD.cols)
+			if err == nil {
+				atomic.StoreUint32(&blk.loaded, 1)
+			}
+		}
+		blk.mutex.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
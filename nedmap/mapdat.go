@@ -23,8 +23,9 @@
  are rounded to the nearest meter.  If your application requires better
  resolution, then don't use this package.
 
- Output files may be raw delta files, or gzipped delta files.  
- Raw files follow this format 
+ Output files are indexed (version 2) files, described below.  Older
+ (version 1) files are raw delta files, or gzipped delta files, and
+ are still read.  Raw files follow this format 
 
  The Header: 
 
@@ -50,11 +51,45 @@
  Otherwise,  the next four bytes (perhaps nybble aligned!) are the elevation of
  the current point. 
 
+ Indexed (version 2, 0x0202) files break the rows into blocks that are
+ compressed separately, so that a reader can seek to the rows it needs
+ and decode only those.  Everything outside the blocks is byte aligned
+ and little endian, and is not compressed.
+
+ The Header:
+
+ byte: escMarker
+ int16: startOfFileMarker
+ int16: fileFormatID (0x0202)
+ float32: ll.Lat ll.Lon ur.Lat ur.Lon
+ int16: rowcount colcount blockrows
+ uint16: number of metadata entries, then for each a key and a value,
+         each a uint16 byte count followed by UTF-8 text.  The keys are
+         name, hdatum, vdatum, units, nodata, source and date; readers
+         skip keys they don't know.
+ int64: file offset of each of the ceil(rowcount/blockrows) blocks,
+        then the offset of the end of the last block
+ uint32: CRC-32 (IEEE) of the uncompressed nybble stream of each block
+ uint32: CRC-32 of the header up to here
+
+ Each Block:
+ deflate (RFC 1951) compressed nybble stream of 'blockrows' rows (fewer
+ in the last block) in the version 1 row format, padded to a byte
+
+ The Footer:
+
+ 32 bytes: SHA-256 digest of everything before the footer
+
+ Files of version 0x0201 are the same, without the metadata, and files
+ of version 0x0200 are also without the checksums and the footer.
+
 */
 package nedmap
 
 import (
+	"bufio"
 	"io"
+	"io/ioutil"
 	"os"
 	"time"
 	"errors"
@@ -69,17 +104,68 @@ import (
 // and a 2D array of elevation points.
 //
 // We can build a MapData object either from a raw floating point ned source file
-// or from a compressed elevation file. 
+// or from a compressed elevation file. A map opened with OpenMap from an
+// indexed file reads its rows as they are needed: until LoadRows has been
+// called for them, rows of Elevation may be nil.
 type MapData struct {
 	MD        MapInfo
 	Elevation [][]float32
+
+	index  *tileIndex  // where the rows come from, for a map read on demand
+	blocks []mapBlock  // the state of each row block of a map read on demand
 }
 
 
 const startOfLineMarker int16 = 0x7fff
 const startOfFileMarker int16 = 0x7ffe
 const escMarker byte = 0x8
-const currentFileVersion int16 = 0x0100
+const streamFileVersion int16 = 0x0100  // one nybble stream, usually gzipped
+const indexedFileVersion int16 = 0x0200 // separately compressed row blocks
+const checkedFileVersion int16 = 0x0201 // row blocks with checksums
+const describedFileVersion int16 = 0x0202 // checksummed row blocks and a description of the map
+const currentFileVersion = describedFileVersion
+
+// The ways a compressed map file can be bad. The reader returns them
+// wrapped in a *FormatError that says where in the file the problem
+// was; test for them with errors.Is.
+var (
+	ErrBadMagic     = errors.New("not a compressed map file")
+	ErrBadRowMarker = errors.New("bad start of row marker")
+	ErrTruncated    = errors.New("compressed map file is truncated")
+	ErrVersion      = errors.New("unsupported compressed map file version")
+	ErrChecksum     = errors.New("compressed map file checksum mismatch")
+	ErrChanged      = errors.New("compressed map file changed after it was opened")
+)
+
+// A problem found while reading a compressed map file.
+type FormatError struct {
+	File   string // the file name, if the map was read from a file
+	Err    error  // one of the Err values above, or the error from the underlying reader
+	Row    int    // the elevation row being read, or -1 if the problem isn't in a row
+	Offset int64  // byte offset of the problem in the uncompressed stream (or row block), -1 if unknown
+	Detail string // what was found, if there is more to say
+}
+
+func (e *FormatError) Error() string {
+	ret := e.Err.Error()
+	if e.Row >= 0 {
+		ret += fmt.Sprintf(" in row %d", e.Row)
+	}
+	if e.Offset >= 0 {
+		ret += fmt.Sprintf(" at offset %d", e.Offset)
+	}
+	if e.File != "" {
+		ret = e.File + ": " + ret
+	}
+	if e.Detail != "" {
+		ret += ": " + e.Detail
+	}
+	return ret
+}
+
+func (e *FormatError) Unwrap() error {
+	return e.Err
+}
 
 // convert a USGS NED map into a delta-compressed format
 // metafile is the filename of an XML file describing the input data set
@@ -111,20 +197,9 @@ func ConvertFile(metafile, fltfile string) (*MapData, string, error) {
 
 	// Now write the compressed map file.
 	// create the filename
-	latmk := 'N'
-	lonmk := 'E'
 	urlat := int(round(float32(md.ur.Lat)))
 	lllon := int(round(float32(md.ll.Lon)))
-		
-	if urlat < 0 {
-		latmk = 'S'
-		urlat = - urlat
-	}
-	if lllon < 0 {
-		lonmk = 'W'
-		lllon = - lllon
-	}
-	cmpfile := fmt.Sprintf("%c%02d%c%03d.dgz", latmk, urlat, lonmk, lllon)
+	cmpfile := tileName(urlat, lllon)
 	return elev, cmpfile, elev.WriteZCompressedMap(cmpfile)
 }
 
@@ -169,14 +244,51 @@ type nybbleInStream struct {
 	odd bool
 	inbuf [inbufSize]byte
 	in_idx int
+	in_len int // number of bytes in inbuf
+	base int64 // offset in the stream of inbuf[0]
+	err error // the error that ended the stream; after it every nybble reads as 0
 }
 
 func (w * nybbleInStream) initIn() {
-	w.in_idx = 0
-	w.rd.Read(w.inbuf[:])
+	w.in_idx, w.in_len, w.base = 0, 0, 0
+	w.err = nil
+	w.fill()
 	w.odd = false
 }
 
+// Refill the input buffer once it has been used up. A reader may return
+// fewer bytes than asked for (gzip often does), so take what comes.
+func (w * nybbleInStream) fill() {
+	w.base += int64(w.in_len)
+	w.in_idx, w.in_len = 0, 0
+	if w.err != nil {
+		return
+	}
+	n, err := io.ReadAtLeast(w.rd, w.inbuf[:], 1)
+	w.in_len = n
+	if n == 0 {
+		w.err = err
+	}
+}
+
+// the offset in the stream of the next byte to be read
+func (w * nybbleInStream) offset() int64 {
+	return w.base + int64(w.in_idx)
+}
+
+// Return the error for a problem in row (-1 for the header) at offset
+// off. If the stream ended early, that is the real problem.
+func (w * nybbleInStream) fail(row int, off int64, err error, detail string) error {
+	if w.err != nil {
+		if (w.err == io.EOF) || (w.err == io.ErrUnexpectedEOF) {
+			return &FormatError{Err: ErrTruncated, Row: row, Offset: w.offset(),
+				Detail: "the data ends early"}
+		}
+		return &FormatError{Err: w.err, Row: row, Offset: w.offset()}
+	}
+	return &FormatError{Err: err, Row: row, Offset: off, Detail: detail}
+}
+
 func (w * nybbleOutStream) terminateOut() {
 	if w.odd {
 		buf := []byte{w.cur}
@@ -202,16 +314,18 @@ func (w * nybbleOutStream) putNybble(v byte) {
 
 func (w * nybbleInStream) getNybble() (byte) {
 	var r byte
+	if w.in_idx >= w.in_len {
+		w.fill()
+		if w.in_len == 0 {
+			return 0
+		}
+	}
 	v := w.inbuf[w.in_idx]
 
 	if w.odd {
 		r = (v >> 4) & 0xf
 		w.odd = false
 		w.in_idx++
-		if w.in_idx == inbufSize {
-			w.rd.Read(w.inbuf[:])
-			w.in_idx = 0
-		}
 	} else {
 		r = v & 0xf
 		w.odd = true
@@ -280,7 +394,7 @@ func (w * nybbleOutStream) writeCompHeader(md * MapInfo) (error) {
 	// ll:(float64, float64) ur:(float64 float64) rows:16 int cols: int16
 	w.put(escMarker)	
 	w.put(startOfFileMarker)
-	w.put(currentFileVersion)	
+	w.put(streamFileVersion)
 		
 	llur := []float32{ float32(md.ll.Lat), float32(md.ll.Lon), float32(md.ur.Lat), float32(md.ur.Lon) }
 	for _,v := range llur {
@@ -299,29 +413,42 @@ func (w * nybbleInStream) readCompHeader(md * MapInfo) (error) {
 	em := w.getNybble()
 	w.getNybble()
 	if em != escMarker {
-		fmt.Printf("got bad nybble, expected esc got %02x\n", em)
+		return w.fail(-1, 0, ErrBadMagic, fmt.Sprintf("expected escape nybble %x, got %x", escMarker, em))
 	}
-	
+
 	// now the SOF marker
+	off := w.offset()
 	sof := w.getInt16()
 	if sof != startOfFileMarker {
-		fmt.Printf("got bad startOfFileMarker, expected %04x got %04x\n", startOfFileMarker, sof)
+		return w.fail(-1, off, ErrBadMagic, fmt.Sprintf("expected start of file marker %04x, got %04x", startOfFileMarker, sof))
 	}
 
-	// get the file version ID .. we don't care much
+	// get the file version ID
+	off = w.offset()
 	fvid := w.getInt16()
-	if fvid != currentFileVersion { 
-		return errors.New(fmt.Sprintf("Got bad file version id: %04x  expected %04x\n", fvid, currentFileVersion))
+	if fvid != streamFileVersion {
+		return w.fail(-1, off, ErrVersion, fmt.Sprintf("got %04x, expected %04x", fvid, streamFileVersion))
 	}
-	
+
 	md.ll.Lat = float64(w.getFloat32())
 	md.ll.Lon = float64(w.getFloat32())
 	md.ur.Lat = float64(w.getFloat32())
 	md.ur.Lon = float64(w.getFloat32())
 
+	off = w.offset()
 	md.rows = int(w.getInt16())
 	md.cols = int(w.getInt16())
-	
+	if w.err != nil {
+		return w.fail(-1, off, ErrTruncated, "")
+	}
+	if (md.rows <= 0) || (md.cols <= 0) {
+		return w.fail(-1, off, ErrBadMagic, fmt.Sprintf("impossible map size %d x %d", md.rows, md.cols))
+	}
+
+	// version 1 files record nothing else about the map
+	md.noData = DefaultNoData
+	md.setDefaults()
+
 	return nil
 }
 
@@ -360,19 +487,26 @@ func (w * nybbleOutStream) writeCompElevation(el, lel float32) (error) {
 	return nil
 }
 
+// Write the map to fname as an indexed (version 2) compressed map file.
 func (m * MapData) WriteZCompressedMap(fname string) (error) {
 	ofd, oerr := os.Create(fname)
 	if oerr != nil {
-		panic(oerr)
+		return oerr
 	}
+	defer ofd.Close()
 
-	
-	wr := gzip.NewWriter(ofd)
-	wcerr := m.WriteCompressedMap(wr)
-	wr.Close()
+	wr := bufio.NewWriter(ofd)
+	wcerr := m.WriteIndexedMap(wr, DefaultBlockRows)
+	if ferr := wr.Flush(); wcerr == nil {
+		wcerr = ferr
+	}
+	if cerr := ofd.Close(); wcerr == nil {
+		wcerr = cerr
+	}
 	return wcerr
 }
 
+// Write the map as a single (version 1) nybble stream.
 func (m * MapData) WriteCompressedMap(outstr io.Writer) (error) {
 	// create a nybble stream
 	ns := nybbleOutStream{wr: outstr, odd: false, cur: 0}
@@ -380,25 +514,28 @@ func (m * MapData) WriteCompressedMap(outstr io.Writer) (error) {
 	// first write the compressed header.
 	ns.writeCompHeader(&m.MD)
 
+	ns.writeRows(m.Elevation)
+
+	ns.terminateOut()
+	
+	return nil
+}
+
+func (w * nybbleOutStream) writeRows(rows [][]float32) {
 	var last_ev float32
-	for i := range m.Elevation {
+	for i := range rows {
 		// row by row...
-		for j := range m.Elevation[i] {
+		for j := range rows[i] {
 			if j == 0 {
-				ns.writeCompRowStart(m.Elevation[i][0])
-				last_ev = m.Elevation[i][0]
+				w.writeCompRowStart(rows[i][0])
+				last_ev = rows[i][0]
 			} else {
-				ev := m.Elevation[i][j]
-				ns.writeCompElevation(ev, last_ev)
+				ev := rows[i][j]
+				w.writeCompElevation(ev, last_ev)
 				last_ev = ev
 			}
 		}
-		
 	}
-
-	ns.terminateOut()
-	
-	return nil
 }
 
 var cvtFloatTable = [...]float32 { 0.0, 1.0, 2.0, 3.0, 4.0, 5.0, 6.0, 7.0, 0.0, -7.0, -6.0, -5.0, -4.0, -3.0, -2.0, -1.0 }
@@ -414,58 +551,109 @@ func (w * nybbleInStream) readCompElevation(last float32) (r float32) {
 	return r
 }
 
-func (w * nybbleInStream) readCompRowStart() (r float32) {
+func (w * nybbleInStream) readCompRowStart(row int) (r float32, err error) {
 	// each row starts with esc, then startOfLineMarker
+	off := w.offset()
 	em := w.getNybble()
 	if em != escMarker {
-		fmt.Printf("got bad nybble, expected esc got %02x\n", em)
+		return 0.0, w.fail(row, off, ErrBadRowMarker, fmt.Sprintf("expected escape nybble %x, got %x", escMarker, em))
 	}
-	// now the SOF marker
-	sof := w.getInt16()
-	if sof != startOfLineMarker {
-		fmt.Printf("got bad startOfLineMarker, expected %04x got %04x\n", startOfFileMarker, sof)
+	// now the SOL marker
+	sol := w.getInt16()
+	if sol != startOfLineMarker {
+		return 0.0, w.fail(row, off, ErrBadRowMarker, fmt.Sprintf("expected start of row marker %04x, got %04x", startOfLineMarker, sol))
 	}
-	
+
 	// now get the elevation
 	r = w.getFloat32()
 
-	return
+	return r, nil
 }
 
+// Read all of a compressed map file of any version, as written by
+// WriteZCompressedMap. Problems with the contents come back as a
+// *FormatError.
 func ReadZCompressedMap(fname string) (* MapData, error) {
-	ifd, ierr := os.Open(fname)
-	if ierr != nil { panic(ierr) }
-	defer ifd.Close()
+	m, err := OpenMap(fname)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.LoadRows(0, m.MD.rows); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
 
+// Read a gzipped version 1 map file.
+func readGzippedMap(ifd io.Reader, fname string) (* MapData, error) {
 	rd, gzerr := gzip.NewReader(ifd)
-	if gzerr != nil { panic(gzerr) }
-	return ReadCompressedMap(rd)
+	if gzerr != nil {
+		return nil, &FormatError{File: fname, Err: ErrBadMagic, Row: -1, Detail: gzerr.Error()}
+	}
+	m, err := ReadCompressedMap(rd)
+	if err == nil {
+		// read to the end of the gzip stream, where its checksum is checked
+		_, err = io.Copy(ioutil.Discard, rd)
+		if err == io.ErrUnexpectedEOF {
+			err = ErrTruncated
+		}
+		if err != nil {
+			err = &FormatError{Err: err, Row: -1, Offset: -1, Detail: "after the last row"}
+		}
+	}
+	if ferr, ok := err.(*FormatError); ok {
+		ferr.File = fname
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
 }
 
+// Read a compressed map from an uncompressed stream. Problems with the
+// contents come back as a *FormatError.
 func ReadCompressedMap(instr io.Reader) (* MapData, error) {
 	ns := nybbleInStream{rd: instr, odd: false}
 	ns.initIn()
-	
+
 	m := new(MapData)
 
-	ns.readCompHeader(&m.MD)
+	if err := ns.readCompHeader(&m.MD); err != nil {
+		return nil, err
+	}
 
 	m.Elevation = make([][]float32, m.MD.rows)
+	if err := ns.readRows(m.Elevation, 0, m.MD.cols); err != nil {
+		return nil, err
+	}
 
-	for i := range m.Elevation {
-		m.Elevation[i] = make([]float32, m.MD.cols)
+	return m, nil
+}
+
+// Read len(rows) rows of cols elevations each, the first of which is
+// row first of the map.
+func (ns * nybbleInStream) readRows(rows [][]float32, first, cols int) (error) {
+	for i := range rows {
+		row := make([]float32, cols)
 
 		// now read each row
-		m.Elevation[i][0] = ns.readCompRowStart()
-		last_el := m.Elevation[i][0]
-		
-		for j := 1; j < m.MD.cols; j++ {
+		start, err := ns.readCompRowStart(first + i)
+		if err != nil {
+			return err
+		}
+		row[0] = start
+		last_el := start
+
+		for j := 1; j < cols; j++ {
 			// get the next elevation
 			el := ns.readCompElevation(last_el)
-			m.Elevation[i][j] = el
+			row[j] = el
 			last_el = el
 		}
+		if ns.err != nil {
+			return ns.fail(first+i, ns.offset(), ErrTruncated, "")
+		}
+		rows[i] = row
 	}
-	
-	return m, nil	
+	return nil
 }
@@ -0,0 +1,259 @@
+// Tests for reading and writing compressed map files of every version.
+package nedmap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/kb1vc/radiopath/location"
+)
+
+// the versions a file can be written as for the tests; the version 1
+// files are either gzipped or raw nybble streams
+type testVersion struct {
+	name    string
+	version int16
+	gzipped bool
+}
+
+var testVersions = []testVersion{
+	{"v0100-raw", streamFileVersion, false},
+	{"v0100-gzip", streamFileVersion, true},
+	{"v0200", indexedFileVersion, false},
+	{"v0201", checkedFileVersion, false},
+	{"v0202", describedFileVersion, false},
+}
+
+// A small map with whole meter elevations (which survive compression
+// unchanged), both small steps and large jumps between neighbors, and
+// a last row block that is only partly full.
+func testMap() *MapData {
+	m := &MapData{}
+	m.MD.ll = location.LatLon{Lat: 41.99, Lon: -72.01}
+	m.MD.ur = location.LatLon{Lat: 43.01, Lon: -70.99}
+	m.MD.rows, m.MD.cols = 70, 50
+	m.MD.name = "Test tile"
+	m.MD.hdatum, m.MD.vdatum = "North American Datum of 1983", "NAVD88"
+	m.MD.units, m.MD.noData = "meters", -32767.0
+	m.MD.source, m.MD.date = "U.S. Geological Survey", "2009"
+	m.Elevation = make([][]float32, m.MD.rows)
+	for r := range m.Elevation {
+		m.Elevation[r] = make([]float32, m.MD.cols)
+		for c := range m.Elevation[r] {
+			el := 200.0 + 150.0*math.Sin(float64(r)/9.0)*math.Cos(float64(c)/7.0)
+			if (r+c)%17 == 0 {
+				el -= 400.0
+			}
+			m.Elevation[r][c] = float32(math.Floor(el))
+		}
+	}
+	return m
+}
+
+// Write m to a file in dir in the given version and return its name.
+func writeTestMap(t *testing.T, m *MapData, dir string, tv testVersion) string {
+	var buf bytes.Buffer
+	switch {
+	case tv.version != streamFileVersion:
+		if err := m.writeIndexedMap(&buf, 16, tv.version); err != nil {
+			t.Fatal(err)
+		}
+	case tv.gzipped:
+		gz := gzip.NewWriter(&buf)
+		m.WriteCompressedMap(gz)
+		if err := gz.Close(); err != nil {
+			t.Fatal(err)
+		}
+	default:
+		m.WriteCompressedMap(&buf)
+	}
+	fname := filepath.Join(dir, tv.name+".dgz")
+	if err := ioutil.WriteFile(fname, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fname
+}
+
+// Replace the file's contents with what fix makes of them.
+func alterFile(t *testing.T, fname string, fix func([]byte) []byte) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fname, fix(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMapFileRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	want := testMap()
+	for _, tv := range testVersions {
+		fname := writeTestMap(t, want, dir, tv)
+
+		integ, err := VerifyMap(fname)
+		if err != nil {
+			t.Fatalf("%s: %v", tv.name, err)
+		}
+		if integ.Version != tv.version {
+			t.Errorf("%s: read version %04x", tv.name, integ.Version)
+		}
+		if checked := tv.gzipped || (tv.version >= checkedFileVersion); integ.Checksummed != checked {
+			t.Errorf("%s: checksummed is %v, expected %v", tv.name, integ.Checksummed, checked)
+		}
+
+		got, err := ReadZCompressedMap(fname)
+		if err != nil {
+			t.Fatalf("%s: %v", tv.name, err)
+		}
+		if (got.MD.Rows() != want.MD.rows) || (got.MD.Cols() != want.MD.cols) {
+			t.Fatalf("%s: map is %d x %d, expected %d x %d", tv.name,
+				got.MD.Rows(), got.MD.Cols(), want.MD.rows, want.MD.cols)
+		}
+		ll, ur := got.MD.Bounds()
+		if (ll.Lat != float64(float32(want.MD.ll.Lat))) || (ur.Lon != float64(float32(want.MD.ur.Lon))) {
+			t.Errorf("%s: bounds are %v %v", tv.name, ll, ur)
+		}
+		for r := range want.Elevation {
+			for c := range want.Elevation[r] {
+				if got.Elevation[r][c] != want.Elevation[r][c] {
+					t.Fatalf("%s: row %d col %d is %f, expected %f", tv.name, r, c,
+						got.Elevation[r][c], want.Elevation[r][c])
+				}
+			}
+		}
+
+		// only version 0x0202 files describe the map
+		if tv.version == describedFileVersion {
+			md := &got.MD
+			if (md.Name() != want.MD.name) || (md.HorizontalDatum() != want.MD.hdatum) ||
+				(md.VerticalDatum() != want.MD.vdatum) || (md.NoData() != want.MD.noData) ||
+				(md.Source() != want.MD.source) || (md.Date() != want.MD.date) {
+				t.Errorf("%s: description is %+v", tv.name, *md)
+			}
+		} else if (got.MD.Name() != "N43W072") || (got.MD.Units() != "meters") || (got.MD.NoData() != DefaultNoData) {
+			t.Errorf("%s: defaults are %q %q %f", tv.name, got.MD.Name(), got.MD.Units(), got.MD.NoData())
+		}
+	}
+}
+
+func TestMapFileChecksums(t *testing.T) {
+	dir := t.TempDir()
+	m := testMap()
+	for _, tv := range testVersions {
+		if tv.version < checkedFileVersion {
+			continue
+		}
+		fname := writeTestMap(t, m, dir, tv)
+		md, err := OpenMap(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		offsets := md.index.offsets
+		nb := len(offsets) - 1
+
+		// somewhere in the fixed header, the index, each block and
+		// the digest
+		places := map[string]int64{
+			"corner":  7,
+			"offsets": offsets[0] - 4*int64(nb) - 4 - 8,
+			"crcs":    offsets[0] - 6,
+			"digest":  offsets[nb] + 10,
+		}
+		for b := 0; b < nb; b++ {
+			places[fmt.Sprintf("block %d", b)] = (offsets[b] + offsets[b+1]) / 2
+		}
+		if tv.version == describedFileVersion {
+			data, err := ioutil.ReadFile(fname)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// a character of the name
+			places["description"] = int64(bytes.Index(data, []byte(m.MD.name))) + 2
+		}
+
+		for what, off := range places {
+			fname := writeTestMap(t, m, dir, tv)
+			alterFile(t, fname, func(data []byte) []byte {
+				data[off] ^= 0x10
+				return data
+			})
+			if _, err := VerifyMap(fname); !errors.Is(err, ErrChecksum) {
+				t.Errorf("%s: changing the %s at %d: got %v, expected a checksum error", tv.name, what, off, err)
+			}
+		}
+	}
+}
+
+func TestMapFileTruncated(t *testing.T) {
+	dir := t.TempDir()
+	m := testMap()
+	for _, tv := range testVersions {
+		fname := writeTestMap(t, m, dir, tv)
+		st, err := os.Stat(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, size := range []int64{12, st.Size() / 2, st.Size() - 1} {
+			fname := writeTestMap(t, m, dir, tv)
+			alterFile(t, fname, func(data []byte) []byte {
+				return data[:size]
+			})
+			if _, err := VerifyMap(fname); !errors.Is(err, ErrTruncated) {
+				t.Errorf("%s: cut to %d of %d bytes: got %v, expected a truncated file error", tv.name, size, st.Size(), err)
+			}
+		}
+	}
+}
+
+// Look up elevations from many goroutines at once in a map that reads
+// its rows on demand; run with -race.
+func TestMapFileConcurrentLookups(t *testing.T) {
+	fname := writeTestMap(t, testMap(), t.TempDir(), testVersions[len(testVersions)-1])
+	want, err := ReadZCompressedMap(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := OpenMap(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				// walk the map from a different place in each goroutine
+				f := math.Mod(float64(w)*0.37+float64(i)*0.013, 1.0)
+				ll := location.LatLon{Lat: 42.0 + f, Lon: -72.0 + math.Mod(f*3.0, 1.0)}
+				for _, how := range []Interpolation{Nearest, Bilinear, Bicubic} {
+					a, err := got.ElevationInterp(ll, how)
+					if err != nil {
+						errs <- err
+						return
+					}
+					if b, _ := want.ElevationInterp(ll, how); a != b {
+						errs <- errors.New(fmt.Sprintf("%v by method %d: got %f, expected %f", ll, how, a, b))
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
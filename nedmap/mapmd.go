@@ -3,18 +3,108 @@ package nedmap
 
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"encoding/xml"
 	"io/ioutil"
+	"strconv"
+	"strings"
 	"github.com/kb1vc/radiopath/location"
 )
 
+// The no data value in USGS GridFloat files, used when the metadata
+// doesn't say otherwise.
+const DefaultNoData = -9999.0
+
+// Everything about a map but its elevations: where it is, how big it is,
+// and where it came from. Maps read from files that predate the fuller
+// header get the USGS defaults for the things the file doesn't record.
 type MapInfo struct {
 	name string // the name of this map segment
 	ll   location.LatLon // location of the lower left corner of the map
 	ur   location.LatLon // location of the upper right corner of the map
 	rows int // number of rows in the elevation grid
 	cols int // number of collumns in the elevation grid
+
+	hdatum string // horizontal datum of the corners
+	vdatum string // vertical datum of the elevations
+	units  string // elevation units
+	noData float64 // the elevation of cells with no data
+	source string // who produced the data
+	date   string // when the data was published
+}
+
+// Return the name of the map: the title of the USGS product it came
+// from, or the tile name (e.g. "N43W072") if that isn't known.
+func (md *MapInfo) Name() string {
+	return md.name
+}
+
+// Return the lower left (southwest) and upper right (northeast) corners
+// of the map. These are the outer edges of the outermost cells.
+func (md *MapInfo) Bounds() (location.LatLon, location.LatLon) {
+	return md.ll, md.ur
+}
+
+// Return the number of rows in the elevation grid.
+func (md *MapInfo) Rows() int {
+	return md.rows
+}
+
+// Return the number of columns in the elevation grid.
+func (md *MapInfo) Cols() int {
+	return md.cols
+}
+
+// Return the height and width of a cell in degrees of latitude and longitude.
+func (md *MapInfo) CellSize() (float64, float64) {
+	return (md.ur.Lat - md.ll.Lat) / float64(md.rows), (md.ur.Lon - md.ll.Lon) / float64(md.cols)
+}
+
+// Return the horizontal datum of the map's corners,
+// e.g. "North American Datum of 1983".
+func (md *MapInfo) HorizontalDatum() string {
+	return md.hdatum
+}
+
+// Return the vertical datum of the elevations,
+// e.g. "North American Vertical Datum of 1988".
+func (md *MapInfo) VerticalDatum() string {
+	return md.vdatum
+}
+
+// Return the units of the elevations in the source data, e.g. "meters".
+func (md *MapInfo) Units() string {
+	return md.units
+}
+
+// Return the elevation given to cells that have no data.
+func (md *MapInfo) NoData() float64 {
+	return md.noData
+}
+
+// Return who produced the data, e.g. "U.S. Geological Survey".
+func (md *MapInfo) Source() string {
+	return md.source
+}
+
+// Return the publication date of the data, as the metadata gives it
+// (usually YYYYMMDD).
+func (md *MapInfo) Date() string {
+	return md.date
+}
+
+// Fill in whatever the source of the map didn't say.
+func (md *MapInfo) setDefaults() {
+	if md.name == "" {
+		urlat := int(round(float32(md.ur.Lat)))
+		lllon := int(round(float32(md.ll.Lon)))
+		md.name = strings.TrimSuffix(tileName(urlat, lllon), ".dgz")
+	}
+	if md.units == "" {
+		md.units = "meters"
+	}
 }
 
 
@@ -23,11 +113,16 @@ type metadata_x struct {
 	Testint string `xml:"testint"`
 	Idinfo idinfo_x  `xml:"idinfo"`
 	Spdoinfo spdoinfo_x  `xml:"spdoinfo"`
+	Spref spref_x `xml:"spref"`
+	Eainfo eainfo_x `xml:"eainfo"`
 	Inner string `xml:",innerxml"`
 }
 
 type idinfo_x struct {
 	Name xml.Name 
+	Origin string `xml:"citation>citeinfo>origin"`
+	Pubdate string `xml:"citation>citeinfo>pubdate"`
+	Title string `xml:"citation>citeinfo>title"`
 	Spdom spdom_x `xml:"spdom"`
 }
 
@@ -55,21 +150,64 @@ type rastinfo_x struct {
 	Colcount int `xml:"colcount"`
 }
 
+type spref_x struct {
+	Name xml.Name
+	Horizdn string `xml:"horizsys>geodetic>horizdn"`
+	Altdatum string `xml:"vertdef>altsys>altdatum"`
+	Altunits string `xml:"vertdef>altsys>altunits"`
+}
+
+// The attribute descriptions, where the no data value is listed
+// as an enumerated domain value.
+type eainfo_x struct {
+	Name xml.Name
+	Edom []edom_x `xml:"detailed>attr>attrdomv>edom"`
+}
+
+type edom_x struct {
+	Value string `xml:"edomv"`
+	Definition string `xml:"edomvd"`
+}
+
+// Read the description of a map from its FGDC metadata (the XML file that
+// comes with each USGS NED tile).
 func GetInfo(instr io.Reader) (MapInfo, error) {
 	xmlContent, err := ioutil.ReadAll(instr)
-	if err != nil { panic(err) }
+	if err != nil { return MapInfo{}, err }
 
 	md := metadata_x{}
 	err2 := xml.Unmarshal(xmlContent, &md)
 	if err2 != nil {
-		panic(err)
+		return MapInfo{}, errors.New(fmt.Sprintf("Can't read the map metadata: %s", err2))
 	}
 
 	var ret MapInfo
-	ret.name = "Idunno"
 	ret.ll.Lat, ret.ll.Lon = md.Idinfo.Spdom.Bounding.South, md.Idinfo.Spdom.Bounding.West
 	ret.ur.Lat, ret.ur.Lon = md.Idinfo.Spdom.Bounding.North, md.Idinfo.Spdom.Bounding.East
 	ret.rows, ret.cols = md.Spdoinfo.Rastinfo.Rowcount, md.Spdoinfo.Rastinfo.Colcount
+	if (ret.rows <= 0) || (ret.cols <= 0) || !(ret.ur.Lat > ret.ll.Lat) || !(ret.ur.Lon > ret.ll.Lon) {
+		return MapInfo{}, errors.New(fmt.Sprintf("The map metadata describes an impossible %d x %d map from %f %f to %f %f",
+			ret.rows, ret.cols, ret.ll.Lat, ret.ll.Lon, ret.ur.Lat, ret.ur.Lon))
+	}
+
+	ret.name = strings.TrimSpace(md.Idinfo.Title)
+	ret.source = strings.TrimSpace(md.Idinfo.Origin)
+	ret.date = strings.TrimSpace(md.Idinfo.Pubdate)
+	ret.hdatum = strings.TrimSpace(md.Spref.Horizdn)
+	ret.vdatum = strings.TrimSpace(md.Spref.Altdatum)
+	ret.units = strings.TrimSpace(md.Spref.Altunits)
+	ret.noData = DefaultNoData
+	for _, e := range md.Eainfo.Edom {
+		def := strings.ToLower(strings.Replace(e.Definition, " ", "", -1))
+		if !strings.Contains(def, "nodata") {
+			continue
+		}
+		if v, err := strconv.ParseFloat(strings.TrimSpace(e.Value), 64); err == nil {
+			ret.noData = v
+			break
+		}
+	}
+	ret.setDefaults()
 
 	return ret, nil
 }
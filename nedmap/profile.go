@@ -0,0 +1,54 @@
+// Terrain profiles: the "slice of the earth" between two points.
+package nedmap
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/kb1vc/radiopath/location"
+)
+
+// One sample along a terrain profile.
+type ProfilePoint struct {
+	Dist      float64         // distance from the start of the path in km
+	Pos       location.LatLon // location of this sample
+	Elevation float64         // terrain elevation in meters
+}
+
+// Anything that can report the terrain elevation (in meters) at a location.
+// A MapData is an ElevationSource for the area it covers.
+type ElevationSource interface {
+	ElevationAt(ll location.LatLon) (float64, error)
+}
+
+// Build a terrain profile along the great-circle path from "from" to "to".
+// Samples are taken no more than spacing km apart, evenly distributed
+// along the path. The first sample is at "from" and the last is at "to".
+func GetProfile(src ElevationSource, from, to location.LatLon, spacing float64) ([]ProfilePoint, error) {
+	if spacing <= 0.0 {
+		return nil, errors.New(fmt.Sprintf("Profile sample spacing must be positive, got %f", spacing))
+	}
+
+	path := location.NewPath(from, to, location.ShortPath)
+
+	steps := int(math.Ceil(path.Dist / spacing))
+	if steps < 1 {
+		steps = 1
+	}
+	pts, err := path.Points(steps + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]ProfilePoint, len(pts))
+	for i, pt := range pts {
+		el, err := src.ElevationAt(pt.Pos)
+		if err != nil {
+			return nil, err
+		}
+		ret[i] = ProfilePoint{Dist: pt.Dist, Pos: pt.Pos, Elevation: el}
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,165 @@
+// A collection of compressed map tiles that covers more than one
+// 1x1 degree NED map segment.
+package nedmap
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/kb1vc/radiopath/location"
+)
+
+// A TileStore answers elevation queries from a directory of compressed
+// map files, each named for the tile it holds (e.g. N43W072.dgz -- the
+// names that ConvertFile produces). Tiles are opened the first time a
+// location within them is requested, and kept in a TileCache. Only the
+// row blocks that are asked for are decoded from indexed (version 2)
+// files; older files are decoded in full when they are opened.
+//
+// Elevations are interpolated between cell centers -- bilinearly,
+// unless the store is told otherwise with SetInterpolation.
+type TileStore struct {
+	dir    string
+	cache  *TileCache
+	interp Interpolation
+}
+
+// Create a tile store for the compressed map files in directory dir,
+// keeping at most budget bytes of decoded tiles in memory.
+// If budget is not positive, the store uses DefaultCacheBudget.
+func NewTileStore(dir string, budget int64) *TileStore {
+	s := &TileStore{dir: dir, interp: Bilinear}
+	s.cache = NewTileCache(budget, s.readTile)
+	return s
+}
+
+// Return the name of the compressed map file for the tile whose
+// northern edge is at latitude urlat and whose western edge is
+// at longitude lllon.
+func tileName(urlat, lllon int) string {
+	latmk := 'N'
+	lonmk := 'E'
+	if urlat < 0 {
+		latmk = 'S'
+		urlat = -urlat
+	}
+	if lllon < 0 {
+		lonmk = 'W'
+		lllon = -lllon
+	}
+	return fmt.Sprintf("%c%02d%c%03d.dgz", latmk, urlat, lonmk, lllon)
+}
+
+// Return the name of the compressed map file that covers ll.
+// Each tile covers one degree of latitude and longitude; a point on
+// an edge belongs to the tile to its north and east.
+func TileNameFor(ll location.LatLon) string {
+	return tileName(int(math.Floor(ll.Lat))+1, int(math.Floor(ll.Lon)))
+}
+
+// How far (in degrees) a tile may reach past the edges of its one
+// degree square: NED tiles overlap their neighbors by a few cells.
+const tileOverlap = 0.01
+
+// Return the names of the tiles next to the one TileNameFor picks for
+// ll that might also hold it, because ll is within tileOverlap of
+// their squares.
+func nearbyTileNames(ll location.LatLon) []string {
+	lat0, lon0 := math.Floor(ll.Lat), math.Floor(ll.Lon)
+	var ret []string
+	for dlat := -1.0; dlat <= 1.0; dlat++ {
+		for dlon := -1.0; dlon <= 1.0; dlon++ {
+			south, west := lat0+dlat, lon0+dlon
+			if ((dlat == 0.0) && (dlon == 0.0)) ||
+				(ll.Lat < south-tileOverlap) || (ll.Lat > south+1.0+tileOverlap) ||
+				(ll.Lon < west-tileOverlap) || (ll.Lon > west+1.0+tileOverlap) {
+				continue
+			}
+			ret = append(ret, tileName(int(south)+1, int(west)))
+		}
+	}
+	return ret
+}
+
+// Read the named tile from the store's directory.
+func (s *TileStore) readTile(name string) (*MapData, error) {
+	fname := filepath.Join(s.dir, name)
+	if _, err := os.Stat(fname); err != nil {
+		return nil, errors.New(fmt.Sprintf("No map tile %s: %s", name, err))
+	}
+	return OpenMap(fname)
+}
+
+// Return the map tile that covers ll, reading it from the store's
+// directory if it isn't in the cache.
+func (s *TileStore) Tile(ll location.LatLon) (*MapData, error) {
+	_, m, err := s.tile(ll)
+	return m, err
+}
+
+// Return the map tile that covers ll and its name. This is the tile
+// TileNameFor picks; if the store doesn't have that one, it is a
+// neighbor that reaches over ll (as tiles do at their edges -- a tile's
+// northern row is a little north of its square, so a point on the edge
+// is in the tile to the south, too).
+func (s *TileStore) tile(ll location.LatLon) (string, *MapData, error) {
+	name := TileNameFor(ll)
+	m, err := s.cache.Get(name)
+	if err == nil {
+		return name, m, nil
+	}
+	if _, serr := os.Stat(filepath.Join(s.dir, name)); !os.IsNotExist(serr) {
+		return name, nil, err
+	}
+	for _, nname := range nearbyTileNames(ll) {
+		if _, serr := os.Stat(filepath.Join(s.dir, nname)); serr != nil {
+			continue
+		}
+		nm, nerr := s.cache.Get(nname)
+		if nerr != nil {
+			continue
+		}
+		if _, _, perr := nm.gridPos(ll); perr == nil {
+			return nname, nm, nil
+		}
+	}
+	return name, nil, err
+}
+
+// Report the hit/miss counters and memory use of the store's tile cache.
+func (s *TileStore) CacheStats() CacheStats {
+	return s.cache.Stats()
+}
+
+// Select the interpolation method used by ElevationAt.
+// This should be set before the store is shared between goroutines.
+func (s *TileStore) SetInterpolation(how Interpolation) {
+	s.interp = how
+}
+
+// Return the elevation (in meters) at ll from whichever tile covers it.
+func (s *TileStore) ElevationAt(ll location.LatLon) (float64, error) {
+	return s.ElevationInterp(ll, s.interp)
+}
+
+// Return the elevation (in meters) at ll from whichever tile covers it,
+// interpolated as selected by "how". A tile whose file has been
+// replaced since it was loaded is loaded again.
+func (s *TileStore) ElevationInterp(ll location.LatLon, how Interpolation) (float64, error) {
+	name, m, err := s.tile(ll)
+	if err != nil {
+		return 0.0, err
+	}
+	el, err := m.ElevationInterp(ll, how)
+	if errors.Is(err, ErrChanged) {
+		s.cache.Forget(name, m)
+		if m, err = s.cache.Get(name); err != nil {
+			return 0.0, err
+		}
+		el, err = m.ElevationInterp(ll, how)
+	}
+	return el, err
+}
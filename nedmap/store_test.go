@@ -0,0 +1,54 @@
+// Tests for finding the tile that holds a point.
+package nedmap
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/kb1vc/radiopath/location"
+)
+
+// Points on (or just past) the north and east edges of a tile belong to
+// the tiles beyond them, but a store without those tiles should still
+// find them in the tile that reaches over them.
+func TestTileStoreEdges(t *testing.T) {
+	dir := t.TempDir()
+	fname := filepath.Join(dir, "N43W072.dgz")
+	if err := testMap().WriteZCompressedMap(fname); err != nil {
+		t.Fatal(err)
+	}
+	m, err := ReadZCompressedMap(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewTileStore(dir, 0)
+
+	for _, ll := range []location.LatLon{
+		{Lat: 42.5, Lon: -71.5},
+		{Lat: 43.0, Lon: -71.5},
+		{Lat: 43.005, Lon: -71.5},
+		{Lat: 42.5, Lon: -71.0},
+		{Lat: 43.0, Lon: -71.0},
+		{Lat: 42.0, Lon: -72.0},
+	} {
+		got, err := s.ElevationAt(ll)
+		if err != nil {
+			t.Errorf("%v: %v", ll, err)
+			continue
+		}
+		if want, _ := m.ElevationInterp(ll, Bilinear); got != want {
+			t.Errorf("%v: got %f, expected %f", ll, got, want)
+		}
+	}
+
+	// beyond the tile's edge cells
+	for _, ll := range []location.LatLon{
+		{Lat: 43.02, Lon: -71.5},
+		{Lat: 42.5, Lon: -70.98},
+		{Lat: 43.5, Lon: -71.5},
+	} {
+		if _, err := s.ElevationAt(ll); err == nil {
+			t.Errorf("%v: found an elevation outside the tile", ll)
+		}
+	}
+}
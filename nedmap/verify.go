@@ -0,0 +1,70 @@
+// Integrity checks on compressed map files.
+package nedmap
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+)
+
+// What VerifyMap found out about a compressed map file.
+type Integrity struct {
+	Version     int16   // the file format version
+	Checksummed bool    // true if the file carries checksums, and they were tested
+	Digest      []byte  // the SHA-256 digest of a version 0x0201 or later file, nil for older versions
+	Info        MapInfo // what the header says about the map
+}
+
+// Check a compressed map file from end to end: decode every row and,
+// for files that carry them, test every checksum and the file digest.
+// The error is nil if the file is sound; problems with the contents
+// come back as a *FormatError. Files without checksums (raw version 1
+// files and version 0x0200 files) can only be checked for a sound
+// structure, so a bit flip in an elevation delta goes unnoticed.
+func VerifyMap(fname string) (Integrity, error) {
+	m, integ, err := openMap(fname)
+	if err != nil {
+		return integ, err
+	}
+	integ.Info = m.MD
+	if err := m.LoadRows(0, m.MD.rows); err != nil {
+		return integ, err
+	}
+	if (m.index == nil) || (m.index.crcs == nil) {
+		return integ, nil
+	}
+
+	f, err := os.Open(fname)
+	if err != nil {
+		return integ, err
+	}
+	defer f.Close()
+
+	// the digest covers everything before the footer
+	end := m.index.offsets[len(m.index.offsets)-1]
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, end)); err != nil {
+		return integ, err
+	}
+	footer := make([]byte, sha256.Size+1)
+	n, err := f.ReadAt(footer, end)
+	if (err != nil) && (err != io.EOF) {
+		return integ, err
+	}
+	if n < sha256.Size {
+		return integ, &FormatError{File: fname, Err: ErrTruncated, Row: -1, Offset: end + int64(n),
+			Detail: "in the file digest"}
+	}
+	if n > sha256.Size {
+		return integ, &FormatError{File: fname, Err: ErrBadMagic, Row: -1, Offset: end + sha256.Size,
+			Detail: "there is more after the file digest"}
+	}
+	integ.Digest = footer[:sha256.Size]
+	if sum := h.Sum(nil); !bytes.Equal(sum, integ.Digest) {
+		return integ, &FormatError{File: fname, Err: ErrChecksum, Row: -1, Offset: end,
+			Detail: fmt.Sprintf("the file digest is %x, expected %x", sum, integ.Digest)}
+	}
+	return integ, nil
+}
@@ -0,0 +1,206 @@
+/*
+Copyright (c) 2012, Matthew H. Reilly (kb1vc)
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are
+met:
+
+    Redistributions of source code must retain the above copyright
+    notice, this list of conditions and the following disclaimer.
+    Redistributions in binary form must reproduce the above copyright
+    notice, this list of conditions and the following disclaimer in
+    the documentation and/or other materials provided with the
+    distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+"AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+// Knife-edge diffraction loss over a terrain profile
+// following ITU-R P.526
+package terrain
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/kb1vc/radiopath/location"
+	"github.com/kb1vc/radiopath/nedmap"
+)
+
+// Methods for estimating diffraction loss over the terrain.
+type DiffractionModel int
+
+const (
+	SingleKnifeEdge DiffractionModel = iota // the single most significant edge
+	Deygout                                 // the main edge plus the main edges of the sub-paths on either side
+	Bullington                              // one equivalent edge where the horizon rays from each end cross
+)
+
+// An edge that contributes to the diffraction loss.
+type Edge struct {
+	Index  int             // index of the edge in the profile, -1 for a Bullington equivalent edge
+	Dist   float64         // distance of the edge from the start of the path in km
+	Pos    location.LatLon // location of the edge
+	Height float64         // height of the edge above the ray between its neighbors in meters
+	V      float64         // the Fresnel-Kirchhoff diffraction parameter
+	Loss   float64         // diffraction loss for this edge in dB
+}
+
+// Estimated diffraction loss over a path.
+type DiffractionResult struct {
+	Model DiffractionModel
+	Loss  float64 // total diffraction loss in dB
+	Edges []Edge  // the edges that make up the loss, most significant first
+}
+
+// the Deygout method looks for edges this many levels deep
+const deygoutDepth = 2
+
+// Return the knife-edge diffraction loss in dB for a diffraction
+// parameter v. (ITU-R P.526 equation 31)
+func KnifeEdgeLoss(v float64) float64 {
+	if v <= -0.78 {
+		return 0.0
+	}
+	return 6.9 + 20.0*math.Log10(math.Sqrt((v-0.1)*(v-0.1)+1.0)+v-0.1)
+}
+
+// Return the Fresnel-Kirchhoff diffraction parameter for an edge
+// h meters above the ray, d1 km from one end of the ray and d2 km
+// from the other, at wavelength lambda meters.
+func diffractionParameter(h, d1, d2, lambda float64) float64 {
+	return h * math.Sqrt(0.002*(d1+d2)/(lambda*d1*d2))
+}
+
+// Estimate the diffraction loss along the path between antennas
+// txHeight meters above the first point in the profile and rxHeight
+// meters above the last point, at a frequency of freqMHz and with
+// effective earth radius factor k, using the selected model.
+func DiffractionLoss(prof []nedmap.ProfilePoint, txHeight, rxHeight, freqMHz, k float64, model DiffractionModel) (DiffractionResult, error) {
+	if freqMHz <= 0.0 {
+		return DiffractionResult{}, errors.New(fmt.Sprintf("Frequency must be positive, got %f MHz", freqMHz))
+	}
+	terr, err := effectiveTerrain(prof, k)
+	if err != nil {
+		return DiffractionResult{}, err
+	}
+
+	lambda := Wavelength(freqMHz)
+	last := len(prof) - 1
+	h0 := terr[0] + txHeight
+	h1 := terr[last] + rxHeight
+
+	ret := DiffractionResult{Model: model}
+	switch model {
+	case SingleKnifeEdge:
+		if e, ok := mainEdge(prof, terr, 0, last, h0, h1, lambda); ok {
+			ret.Edges = []Edge{e}
+		}
+	case Deygout:
+		ret.Edges = deygoutEdges(prof, terr, 0, last, h0, h1, lambda, deygoutDepth)
+	case Bullington:
+		if e, ok := bullingtonEdge(prof, terr, h0, h1, lambda); ok {
+			ret.Edges = []Edge{e}
+		}
+	default:
+		return DiffractionResult{}, errors.New(fmt.Sprintf("Unknown diffraction model %d", model))
+	}
+
+	for _, e := range ret.Edges {
+		ret.Loss += e.Loss
+	}
+
+	return ret, nil
+}
+
+// Find the edge with the largest diffraction parameter between
+// profile points i0 and i1, for a ray from height h0 at i0 to h1 at i1.
+// Returns false if there are no points between i0 and i1, or if no edge
+// comes close enough to the ray to cause any loss.
+func mainEdge(prof []nedmap.ProfilePoint, terr []float64, i0, i1 int, h0, h1, lambda float64) (Edge, bool) {
+	d0 := prof[i0].Dist
+	dist := prof[i1].Dist - d0
+
+	var ret Edge
+	found := false
+	for i := i0 + 1; i < i1; i++ {
+		d1 := prof[i].Dist - d0
+		d2 := dist - d1
+		if (d1 <= 0.0) || (d2 <= 0.0) {
+			continue
+		}
+		h := terr[i] - (h0 + (h1-h0)*d1/dist)
+		v := diffractionParameter(h, d1, d2, lambda)
+		if !found || (v > ret.V) {
+			ret = Edge{Index: i, Dist: prof[i].Dist, Pos: prof[i].Pos, Height: h, V: v}
+			found = true
+		}
+	}
+
+	if !found || (ret.V <= -0.78) {
+		return Edge{}, false
+	}
+	ret.Loss = KnifeEdgeLoss(ret.V)
+	return ret, true
+}
+
+// Find the main edge between profile points i0 and i1 and then,
+// down to "depth" levels, the main edges of the sub-paths between
+// each end and that edge.
+func deygoutEdges(prof []nedmap.ProfilePoint, terr []float64, i0, i1 int, h0, h1, lambda float64, depth int) []Edge {
+	e, ok := mainEdge(prof, terr, i0, i1, h0, h1, lambda)
+	if !ok {
+		return nil
+	}
+
+	ret := []Edge{e}
+	if depth > 1 {
+		ret = append(ret, deygoutEdges(prof, terr, i0, e.Index, h0, terr[e.Index], lambda, depth-1)...)
+		ret = append(ret, deygoutEdges(prof, terr, e.Index, i1, terr[e.Index], h1, lambda, depth-1)...)
+	}
+	return ret
+}
+
+// Find the Bullington equivalent edge. (ITU-R P.526 section 4.5)
+//
+// If the path is line of sight, the equivalent edge is the point with
+// the largest diffraction parameter. Otherwise, it is where the ray from
+// the transmitter that grazes the transmitter's horizon crosses the ray
+// from the receiver that grazes the receiver's horizon. Returns false
+// if no edge causes any loss.
+func bullingtonEdge(prof []nedmap.ProfilePoint, terr []float64, h0, h1, lambda float64) (Edge, bool) {
+	last := len(prof) - 1
+	dist := prof[last].Dist
+
+	stim := math.Inf(-1) // steepest slope from the transmitter to any point
+	srim := math.Inf(-1) // steepest slope from the receiver to any point
+	for i := 1; i < last; i++ {
+		stim = math.Max(stim, (terr[i]-h0)/prof[i].Dist)
+		srim = math.Max(srim, (terr[i]-h1)/(dist-prof[i].Dist))
+	}
+	str := (h1 - h0) / dist
+
+	if stim < str {
+		return mainEdge(prof, terr, 0, last, h0, h1, lambda)
+	}
+
+	db := (h1 - h0 + srim*dist) / (stim + srim)
+	h := h0 + stim*db - (h0 + str*db)
+	v := diffractionParameter(h, db, dist-db, lambda)
+
+	az, _, _ := prof[0].Pos.Bearing(prof[last].Pos)
+	return Edge{Index: -1, Dist: db, Pos: prof[0].Pos.OnPath(az, db),
+		Height: h, V: v, Loss: KnifeEdgeLoss(v)}, true
+}
@@ -0,0 +1,109 @@
+/*
+Copyright (c) 2012, Matthew H. Reilly (kb1vc)
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are
+met:
+
+    Redistributions of source code must retain the above copyright
+    notice, this list of conditions and the following disclaimer.
+    Redistributions in binary form must reproduce the above copyright
+    notice, this list of conditions and the following disclaimer in
+    the documentation and/or other materials provided with the
+    distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+"AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+// Fresnel zone clearance along a terrain profile
+package terrain
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/kb1vc/radiopath/nedmap"
+)
+
+// speed of light in meters per second
+const SpeedOfLight = 299792458.0
+
+// Return the wavelength in meters for a frequency in MHz.
+func Wavelength(freqMHz float64) float64 {
+	return SpeedOfLight / (freqMHz * 1.0e6)
+}
+
+// Return the radius (in meters) of the nth Fresnel zone at d1 km from
+// one end of a path and d2 km from the other, for a frequency in MHz.
+func FresnelRadius(n int, d1, d2, freqMHz float64) float64 {
+	if (d1 <= 0.0) || (d2 <= 0.0) {
+		return 0.0
+	}
+	d1m, d2m := d1*1000.0, d2*1000.0
+	return math.Sqrt(float64(n) * Wavelength(freqMHz) * d1m * d2m / (d1m + d2m))
+}
+
+// Fresnel zone clearance at one point along a path.
+type FresnelPoint struct {
+	Dist      float64 // distance from the start of the path in km
+	Radius    float64 // radius of the first Fresnel zone in meters
+	Clearance float64 // height of the ray above the terrain in meters, negative if obstructed
+	Ratio     float64 // Clearance / Radius, +Inf at the ends of the path
+}
+
+// Fresnel zone clearance over a whole path.
+type FresnelResult struct {
+	Points   []FresnelPoint // one entry for each point in the profile
+	Worst    int            // index of the point with the smallest clearance ratio, -1 if none
+	MinRatio float64        // the smallest clearance ratio over the path
+}
+
+// Return true if at least "fraction" of the first Fresnel zone is
+// clear everywhere along the path. (The usual rule of thumb for
+// microwave paths is a fraction of 0.6.)
+func (r FresnelResult) Clear(fraction float64) bool {
+	return r.MinRatio >= fraction
+}
+
+// Find the first Fresnel zone clearance along the path between antennas
+// txHeight meters above the first point in the profile and rxHeight
+// meters above the last point, at a frequency of freqMHz. The earth's
+// curvature is accounted for with an effective earth radius factor k.
+func FresnelClearance(prof []nedmap.ProfilePoint, txHeight, rxHeight, freqMHz, k float64) (FresnelResult, error) {
+	if freqMHz <= 0.0 {
+		return FresnelResult{}, errors.New(fmt.Sprintf("Frequency must be positive, got %f MHz", freqMHz))
+	}
+	terr, err := effectiveTerrain(prof, k)
+	if err != nil {
+		return FresnelResult{}, err
+	}
+	ray := rayHeights(prof, txHeight, rxHeight)
+	dist := prof[len(prof)-1].Dist
+
+	ret := FresnelResult{Points: make([]FresnelPoint, len(prof)), Worst: -1, MinRatio: math.Inf(1)}
+	for i, p := range prof {
+		fp := FresnelPoint{Dist: p.Dist, Clearance: ray[i] - terr[i], Ratio: math.Inf(1)}
+		fp.Radius = FresnelRadius(1, p.Dist, dist-p.Dist, freqMHz)
+		if fp.Radius > 0.0 {
+			fp.Ratio = fp.Clearance / fp.Radius
+		}
+		if fp.Ratio < ret.MinRatio {
+			ret.Worst, ret.MinRatio = i, fp.Ratio
+		}
+		ret.Points[i] = fp
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,159 @@
+/*
+Copyright (c) 2012, Matthew H. Reilly (kb1vc)
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are
+met:
+
+    Redistributions of source code must retain the above copyright
+    notice, this list of conditions and the following disclaimer.
+    Redistributions in binary form must reproduce the above copyright
+    notice, this list of conditions and the following disclaimer in
+    the documentation and/or other materials provided with the
+    distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+"AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package terrain
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/kb1vc/radiopath/location"
+	"github.com/kb1vc/radiopath/nedmap"
+)
+
+// Return the distance in km to the smooth-earth radio horizon of an
+// antenna h meters above the surface, for an effective earth radius
+// factor k. The surface is sea level for StationHorizon, so h may be
+// negative; there is no horizon then.
+func RadioHorizon(h, k float64) float64 {
+	if h <= 0.0 {
+		return 0.0
+	}
+	return math.Sqrt(2.0 * k * EarthRadius * h / 1000.0)
+}
+
+// Return the longest smooth-earth line of sight path in km between
+// antennas h1 and h2 meters above the surface.
+func MaxLOSDistance(h1, h2, k float64) float64 {
+	return RadioHorizon(h1, k) + RadioHorizon(h2, k)
+}
+
+// Return the smooth-earth radio horizon in km of an antenna antHeight
+// meters above the ground at "at": the horizon over a sea level earth
+// for the antenna's height above sea level.
+func StationHorizon(src nedmap.ElevationSource, at location.LatLon, antHeight, k float64) (float64, error) {
+	if err := checkAntenna(antHeight, k); err != nil {
+		return 0.0, err
+	}
+	el, err := src.ElevationAt(at)
+	if err != nil {
+		return 0.0, err
+	}
+	return RadioHorizon(el+antHeight, k), nil
+}
+
+// Check the height above ground of an antenna and the effective earth
+// radius factor that its horizon is found with.
+func checkAntenna(antHeight, k float64) error {
+	if !(antHeight >= 0.0) || math.IsInf(antHeight, 0) {
+		return errors.New(fmt.Sprintf("Antenna height must be zero or more meters above the ground, got %f", antHeight))
+	}
+	if !(k > 0.0) || math.IsInf(k, 0) {
+		return errors.New(fmt.Sprintf("Effective earth radius factor must be positive, got %f", k))
+	}
+	return nil
+}
+
+// The terrain-limited horizon seen from a station along one azimuth.
+type Horizon struct {
+	Azimuth float64             // degrees clockwise from north
+	Index   int                 // index in the profile of the horizon point
+	Point   nedmap.ProfilePoint // the terrain that limits the view
+	Angle   float64             // elevation angle of the horizon from the antenna in degrees, negative below horizontal
+}
+
+// Return the elevation angle in degrees from an antenna h0 meters
+// above sea level to terrain el meters above sea level d km away,
+// for an effective earth radius factor k.
+func elevationAngle(h0, el, d, k float64) float64 {
+	drop := d * d * 1000.0 / (2.0 * k * EarthRadius)
+	return math.Atan2(el-h0-drop, d*1000.0) * 180.0 / math.Pi
+}
+
+// Find the horizon in a terrain profile for an antenna antHeight meters
+// above the first point: the point that subtends the greatest elevation
+// angle once the earth's curvature is accounted for. Terrain beyond it
+// is hidden unless it rises above the line to the horizon.
+func ProfileHorizon(prof []nedmap.ProfilePoint, antHeight, k float64) (Horizon, error) {
+	if len(prof) < 2 {
+		return Horizon{}, errors.New(fmt.Sprintf("A path profile needs at least two points, got %d", len(prof)))
+	}
+	if err := checkAntenna(antHeight, k); err != nil {
+		return Horizon{}, err
+	}
+
+	h0 := prof[0].Elevation + antHeight
+	ret := Horizon{Angle: math.Inf(-1)}
+	for i := 1; i < len(prof); i++ {
+		a := elevationAngle(h0, prof[i].Elevation, prof[i].Dist, k)
+		if a > ret.Angle {
+			ret.Index, ret.Point, ret.Angle = i, prof[i], a
+		}
+	}
+	ret.Azimuth, _, _ = prof[0].Pos.Bearing(prof[len(prof)-1].Pos)
+	return ret, nil
+}
+
+// Find the terrain-limited horizon from an antenna antHeight meters
+// above the ground at "at", looking along azimuth az (degrees) out to
+// maxDist km, with terrain samples no more than spacing km apart.
+// maxDist should reach beyond the smooth-earth horizon (StationHorizon)
+// or the result is only the highest point within maxDist.
+func TerrainHorizon(src nedmap.ElevationSource, at location.LatLon, antHeight, az, maxDist, spacing, k float64) (Horizon, error) {
+	if !(maxDist > 0.0) {
+		return Horizon{}, errors.New(fmt.Sprintf("Horizon search distance must be positive, got %f", maxDist))
+	}
+	prof, err := nedmap.GetProfile(src, at, at.OnPath(az, maxDist), spacing)
+	if err != nil {
+		return Horizon{}, err
+	}
+	ret, err := ProfileHorizon(prof, antHeight, k)
+	ret.Azimuth = az
+	return ret, err
+}
+
+// Find the terrain-limited horizon all the way around a station: one
+// Horizon for every step degrees of azimuth starting at north, each
+// searched out to maxDist km with samples no more than spacing km apart.
+func HorizonProfile(src nedmap.ElevationSource, at location.LatLon, antHeight, step, maxDist, spacing, k float64) ([]Horizon, error) {
+	if !(step > 0.0) || (step > 360.0) {
+		return nil, errors.New(fmt.Sprintf("Azimuth step must be in (0, 360] degrees, got %f", step))
+	}
+
+	n := int(math.Ceil(360.0/step - 1.0e-9))
+	ret := make([]Horizon, n)
+	for i := range ret {
+		h, err := TerrainHorizon(src, at, antHeight, step*float64(i), maxDist, spacing, k)
+		if err != nil {
+			return nil, err
+		}
+		ret[i] = h
+	}
+	return ret, nil
+}
@@ -0,0 +1,119 @@
+/*
+Copyright (c) 2012, Matthew H. Reilly (kb1vc)
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are
+met:
+
+    Redistributions of source code must retain the above copyright
+    notice, this list of conditions and the following disclaimer.
+    Redistributions in binary form must reproduce the above copyright
+    notice, this list of conditions and the following disclaimer in
+    the documentation and/or other materials provided with the
+    distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+"AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+// Radio path analysis over terrain profiles: line of sight, Fresnel
+// zone clearance and diffraction over the "slice of the earth"
+// between two stations.
+package terrain
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/kb1vc/radiopath/nedmap"
+)
+
+// mean radius of the earth in km
+const EarthRadius = 6371.0
+
+// effective earth radius factor for a standard atmosphere
+const DefaultK = 4.0 / 3.0
+
+// Return the height (in meters) of the earth's surface above the
+// straight chord between the ends of a path, at d1 km from one end
+// and d2 km from the other, for an effective earth radius factor k.
+func EarthBulge(d1, d2, k float64) float64 {
+	return d1 * d2 * 1000.0 / (2.0 * k * EarthRadius)
+}
+
+// The verdict of a line of sight test.
+type LOSResult struct {
+	Clear     bool                // true if the ray clears the terrain everywhere along the path
+	Worst     int                 // index in the profile of the point with the least clearance, -1 if none
+	Point     nedmap.ProfilePoint // the profile point with the least clearance
+	Clearance float64             // height of the ray above the terrain at that point in meters, negative if obstructed
+}
+
+// Check the profile and return the effective terrain heights: the
+// terrain elevation plus the earth bulge for effective earth radius
+// factor k, for every point in the profile.
+func effectiveTerrain(prof []nedmap.ProfilePoint, k float64) ([]float64, error) {
+	if len(prof) < 2 {
+		return nil, errors.New(fmt.Sprintf("A path profile needs at least two points, got %d", len(prof)))
+	}
+	if k <= 0.0 {
+		return nil, errors.New(fmt.Sprintf("Effective earth radius factor must be positive, got %f", k))
+	}
+
+	dist := prof[len(prof)-1].Dist
+	ret := make([]float64, len(prof))
+	for i, p := range prof {
+		ret[i] = p.Elevation + EarthBulge(p.Dist, dist-p.Dist, k)
+	}
+	return ret, nil
+}
+
+// Return the height of the straight ray between the two antennas at each
+// point in the profile. txHeight and rxHeight are the antenna heights
+// above ground (in meters) at the first and last points of the profile.
+func rayHeights(prof []nedmap.ProfilePoint, txHeight, rxHeight float64) []float64 {
+	last := len(prof) - 1
+	h0 := prof[0].Elevation + txHeight
+	h1 := prof[last].Elevation + rxHeight
+	dist := prof[last].Dist
+
+	ret := make([]float64, len(prof))
+	for i, p := range prof {
+		ret[i] = h0 + (h1-h0)*p.Dist/dist
+	}
+	return ret
+}
+
+// Test for line of sight between antennas txHeight meters above the
+// first point in the profile and rxHeight meters above the last point.
+// The earth's curvature is accounted for with an effective earth radius
+// of k times the real radius. (k is usually DefaultK.)
+func LineOfSight(prof []nedmap.ProfilePoint, txHeight, rxHeight, k float64) (LOSResult, error) {
+	terr, err := effectiveTerrain(prof, k)
+	if err != nil {
+		return LOSResult{}, err
+	}
+	ray := rayHeights(prof, txHeight, rxHeight)
+
+	ret := LOSResult{Clear: true, Worst: -1, Clearance: math.Inf(1)}
+	for i := 1; i < len(prof)-1; i++ {
+		cl := ray[i] - terr[i]
+		if cl < ret.Clearance {
+			ret.Worst, ret.Point, ret.Clearance = i, prof[i], cl
+		}
+	}
+	ret.Clear = ret.Clearance >= 0.0
+
+	return ret, nil
+}